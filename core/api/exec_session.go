@@ -0,0 +1,462 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+)
+
+// execReapInterval is how often ExecSessionManager sweeps for sessions
+// whose pump has finished on its own (no explicit DELETE /exec/{id}), the
+// same cadence family as firecracker.Provider's expiry sweep.
+const execReapInterval = 30 * time.Second
+
+// execScrollbackBytes bounds how much recent output a session keeps for
+// clients that reattach after a drop, so a long-running session with a
+// chatty command doesn't grow memory without limit.
+const execScrollbackBytes = 64 * 1024
+
+// ExecCreateRequest describes a session to create via POST /exec/{sandboxID}.
+type ExecCreateRequest struct {
+	Cmd     []string          `json:"cmd"`
+	Tty     bool              `json:"tty"`
+	Env     map[string]string `json:"env"`
+	WorkDir string            `json:"workdir"`
+	Cols    uint16            `json:"cols"`
+	Rows    uint16            `json:"rows"`
+	Record  bool              `json:"record,omitempty"`
+}
+
+// ExecCreateResponse is returned by POST /exec/{sandboxID}.
+type ExecCreateResponse struct {
+	SessionID string `json:"sessionID"`
+}
+
+// ExecStatusResponse is returned by GET /exec/{sessionID}, mirroring
+// Docker's own ContainerExecInspect.
+type ExecStatusResponse struct {
+	Running  bool `json:"running"`
+	ExitCode *int `json:"exitCode,omitempty"`
+}
+
+// ringBuffer is a bounded byte buffer retaining only the most recent writes,
+// used to give a reattaching client scrollback without buffering a session's
+// entire lifetime of output.
+type ringBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	cap int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{cap: capacity}
+}
+
+func (r *ringBuffer) Write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > r.cap {
+		r.buf = r.buf[len(r.buf)-r.cap:]
+	}
+}
+
+func (r *ringBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]byte, len(r.buf))
+	copy(out, r.buf)
+	return out
+}
+
+// ExecSession is a long-lived exec that keeps running independently of any
+// single attached WebSocket, so a dropped connection (mobile network
+// hiccup, laptop sleep, page refresh) doesn't kill the process underneath
+// it. Output is fanned out live to every attached subscriber and also kept
+// in a bounded ringBuffer so a client that reattaches later gets the recent
+// scrollback before live output resumes. It's backend-agnostic: stdin/resize
+// are plain closures, so a session backed by a Docker exec and one backed
+// by an SSH session behave identically from here up.
+type ExecSession struct {
+	ID        string
+	SandboxID string
+	Tty       bool
+
+	stdin    io.Writer
+	closer   io.Closer
+	resize   ResizeFunc
+	statusFn func(ctx context.Context) (ExecStatusResponse, error)
+	rec      *Recorder
+
+	scrollback *ringBuffer
+
+	mu         sync.Mutex
+	subs       map[int]chan []byte
+	nextSub    int
+	done       chan struct{}
+	finishedAt time.Time
+}
+
+// subscribe registers a new output subscriber and returns the current
+// scrollback plus the channel future output will arrive on. The snapshot
+// and subscription happen under the same lock so no output is lost or
+// duplicated between the two.
+func (s *ExecSession) subscribe() ([]byte, chan []byte, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextSub
+	s.nextSub++
+	ch := make(chan []byte, 256)
+	s.subs[id] = ch
+	return s.scrollback.Bytes(), ch, id
+}
+
+func (s *ExecSession) unsubscribe(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.subs[id]; ok {
+		delete(s.subs, id)
+		close(ch)
+	}
+}
+
+func (s *ExecSession) broadcast(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.subs {
+		select {
+		case ch <- p:
+		default:
+			// Slow subscriber: drop rather than block the pump: the
+			// scrollback buffer still has the data for reattachment.
+		}
+	}
+}
+
+// Write implements io.Writer so ExecSession itself can be handed to the
+// exec session's stdin-writing caller; used for the "data" control frame.
+func (s *ExecSession) Write(p []byte) (int, error) {
+	if s.rec != nil {
+		s.rec.Input(p)
+	}
+	return s.stdin.Write(p)
+}
+
+// Resize applies a new PTY size to the running exec.
+func (s *ExecSession) Resize(ctx context.Context, cols, rows uint16) error {
+	if s.rec != nil {
+		s.rec.Resize(cols, rows)
+	}
+	return s.resize(cols, rows)
+}
+
+// Status returns the session's current running/exit state. Sessions that
+// came from a backend with its own inspect call (Docker exec) report it
+// via statusFn; sessions that only know "has the pump goroutine exited"
+// (e.g. a TerminalBackend-started shell) fall back to that, which is
+// enough to know the session is over even though no exit code is known.
+func (s *ExecSession) Status(ctx context.Context) (ExecStatusResponse, error) {
+	if s.statusFn != nil {
+		return s.statusFn(ctx)
+	}
+	select {
+	case <-s.done:
+		return ExecStatusResponse{Running: false}, nil
+	default:
+		return ExecStatusResponse{Running: true}, nil
+	}
+}
+
+// pump reads the exec's output until it ends, writing every chunk to the
+// scrollback buffer and fanning it out live to attached subscribers. It is
+// the only goroutine that reads the underlying connection, so it keeps
+// running across reattaches.
+func (s *ExecSession) pump(reader io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			s.scrollback.Write(chunk)
+			s.broadcast(chunk)
+			if s.rec != nil {
+				s.rec.Output(chunk)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("exec session %s: read failed: %v", s.ID, err)
+			}
+			break
+		}
+	}
+
+	if s.rec != nil {
+		if err := s.rec.Close(); err != nil {
+			log.Printf("exec session %s: failed to close recording: %v", s.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	for id, ch := range s.subs {
+		delete(s.subs, id)
+		close(ch)
+	}
+	s.finishedAt = time.Now()
+	close(s.done)
+	s.mu.Unlock()
+}
+
+// Kill best-effort terminates the session by closing its stdin/connection.
+// Docker's exec API has no direct "kill this exec" call, so closing the
+// hijacked connection (which closes the process's stdin) is the same
+// mechanism an interactive client disconnecting would trigger; a process
+// that ignores EOF on stdin will keep running until the container does.
+func (s *ExecSession) Kill() error {
+	return s.closer.Close()
+}
+
+// newExecSession wires up an ExecSession around an already-started
+// connection, shared by every way of creating one (a Docker cmd exec, or a
+// TerminalBackend's interactive shell). Callers still own starting the
+// pump goroutine themselves, since they're the ones holding the reader.
+func newExecSession(sandboxID string, tty bool, stdin io.Writer, closer io.Closer, resize ResizeFunc, statusFn func(ctx context.Context) (ExecStatusResponse, error)) *ExecSession {
+	return &ExecSession{
+		ID:         uuid.New().String(),
+		SandboxID:  sandboxID,
+		Tty:        tty,
+		stdin:      stdin,
+		closer:     closer,
+		resize:     resize,
+		statusFn:   statusFn,
+		scrollback: newRingBuffer(execScrollbackBytes),
+		subs:       make(map[int]chan []byte),
+		done:       make(chan struct{}),
+	}
+}
+
+// ExecSessionManager owns every long-lived exec session, keyed by a
+// server-issued session ID, independent of any WebSocket connection
+// attached to it.
+type ExecSessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*ExecSession
+
+	// recordDir is where asciicast v2 recordings land; recording is
+	// skipped entirely if empty. recordAll forces every session to record
+	// regardless of the per-request Record flag.
+	recordDir string
+	recordAll bool
+
+	reapInterval time.Duration
+	reapAfter    time.Duration
+}
+
+// NewExecSessionManager returns an empty manager. recordDir is the
+// directory recordings are written to (recording is disabled if empty);
+// recordAll, when true, records every session regardless of the per-request
+// Record flag.
+//
+// A session that finishes on its own (process exit, dropped connection)
+// without an explicit DELETE /exec/{id} would otherwise sit in the
+// registry forever, so a background sweep reaps any session that's been
+// done for longer than reapAfter; the grace period gives a client that's
+// polling GET /exec/{id} a window to observe the final exit code first.
+func NewExecSessionManager(recordDir string, recordAll bool) *ExecSessionManager {
+	m := &ExecSessionManager{
+		sessions:     make(map[string]*ExecSession),
+		recordDir:    recordDir,
+		recordAll:    recordAll,
+		reapInterval: execReapInterval,
+		reapAfter:    5 * time.Minute,
+	}
+	go m.reapLoop()
+	return m
+}
+
+func (m *ExecSessionManager) reapLoop() {
+	ticker := time.NewTicker(m.reapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.reapFinished()
+	}
+}
+
+// reapFinished removes sessions whose pump finished more than reapAfter
+// ago. Kill is best-effort and a no-op in practice here since the
+// underlying connection is already closed by the time pump has exited.
+func (m *ExecSessionManager) reapFinished() {
+	now := time.Now()
+	var toDelete []string
+
+	m.mu.Lock()
+	for id, session := range m.sessions {
+		session.mu.Lock()
+		finishedAt := session.finishedAt
+		session.mu.Unlock()
+		if !finishedAt.IsZero() && now.Sub(finishedAt) > m.reapAfter {
+			toDelete = append(toDelete, id)
+		}
+	}
+	for _, id := range toDelete {
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range toDelete {
+		log.Printf("exec session %s: reaped after finishing", id)
+	}
+}
+
+// Create starts a new exec in containerID (part of sandboxID) and registers
+// it under a fresh session ID. The session keeps running after this call
+// returns, even before anything ever attaches to it.
+func (m *ExecSessionManager) Create(ctx context.Context, cli *client.Client, sandboxID, containerID string, req ExecCreateRequest) (*ExecSession, error) {
+	cmd := req.Cmd
+	if len(cmd) == 0 {
+		cmd = []string{"/bin/sh"}
+	}
+
+	var env []string
+	for k, v := range req.Env {
+		env = append(env, k+"="+v)
+	}
+
+	execID, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		Tty:          req.Tty,
+		Env:          env,
+		WorkingDir:   req.WorkDir,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec create failed: %w", err)
+	}
+
+	// ContainerExecAttach itself starts the process (it's the non-detached
+	// counterpart to ContainerExecStart); calling ContainerExecStart after
+	// it targets an already-running exec and the daemon rejects it with a
+	// 409 Conflict.
+	resp, err := cli.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{Tty: req.Tty})
+	if err != nil {
+		return nil, fmt.Errorf("exec attach failed: %w", err)
+	}
+
+	if req.Tty && req.Cols > 0 && req.Rows > 0 {
+		if err := cli.ContainerExecResize(ctx, execID.ID, container.ResizeOptions{Height: uint(req.Rows), Width: uint(req.Cols)}); err != nil {
+			log.Printf("exec session: initial resize failed: %v", err)
+		}
+	}
+
+	resize := func(cols, rows uint16) error {
+		return cli.ContainerExecResize(context.Background(), execID.ID, container.ResizeOptions{Height: uint(rows), Width: uint(cols)})
+	}
+	statusFn := func(ctx context.Context) (ExecStatusResponse, error) {
+		inspect, err := cli.ContainerExecInspect(ctx, execID.ID)
+		if err != nil {
+			return ExecStatusResponse{}, err
+		}
+		resp := ExecStatusResponse{Running: inspect.Running}
+		if !inspect.Running {
+			code := inspect.ExitCode
+			resp.ExitCode = &code
+		}
+		return resp, nil
+	}
+
+	session := newExecSession(sandboxID, req.Tty, resp.Conn, resp.Conn, resize, statusFn)
+
+	if m.recordDir != "" && (req.Record || m.recordAll) {
+		cols, rows := req.Cols, req.Rows
+		if cols == 0 {
+			cols = defaultCols
+		}
+		if rows == 0 {
+			rows = defaultRows
+		}
+		rec, err := NewRecorder(recordingPath(m.recordDir, sandboxID, session.ID), cols, rows)
+		if err != nil {
+			log.Printf("exec session %s: recording disabled: %v", session.ID, err)
+		} else {
+			session.rec = rec
+		}
+	}
+
+	go session.pump(resp.Reader)
+
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+
+	return session, nil
+}
+
+// CreateFromBackend starts an interactive shell via backend (a Docker exec
+// or an SSH session, see TerminalBackend) and registers it the same way
+// Create does. It's the path TerminalHandler uses: a single shell per
+// connection, so it doesn't need Create's Cmd/Env/WorkDir knobs, just
+// whichever backend the provider resolved for sandboxID.
+func (m *ExecSessionManager) CreateFromBackend(ctx context.Context, backend TerminalBackend, sandboxID string, cols, rows uint16, record bool) (*ExecSession, error) {
+	conn, resize, err := backend.Start(ctx, sandboxID, cols, rows)
+	if err != nil {
+		return nil, fmt.Errorf("terminal backend start failed: %w", err)
+	}
+
+	session := newExecSession(sandboxID, true, conn, conn, resize, nil)
+
+	if m.recordDir != "" && (record || m.recordAll) {
+		if cols == 0 {
+			cols = defaultCols
+		}
+		if rows == 0 {
+			rows = defaultRows
+		}
+		rec, err := NewRecorder(recordingPath(m.recordDir, sandboxID, session.ID), cols, rows)
+		if err != nil {
+			log.Printf("exec session %s: recording disabled: %v", session.ID, err)
+		} else {
+			session.rec = rec
+		}
+	}
+
+	go session.pump(conn)
+
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+
+	return session, nil
+}
+
+// Get returns the session for sessionID, or nil if it doesn't exist (either
+// never created or already reaped by Delete).
+func (m *ExecSessionManager) Get(sessionID string) *ExecSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[sessionID]
+}
+
+// Delete kills the session (best-effort) and removes it from the registry.
+func (m *ExecSessionManager) Delete(sessionID string) error {
+	m.mu.Lock()
+	session, ok := m.sessions[sessionID]
+	delete(m.sessions, sessionID)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("exec session %s not found", sessionID)
+	}
+	return session.Kill()
+}
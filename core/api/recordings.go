@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RecordingHandler serves asciicast v2 recordings written by Recorder:
+//
+//	GET /recordings/{id}          download the raw .cast file
+//	GET /recordings/{id}/stream   replay it over WebSocket, honoring the
+//	                              recorded timings (optional ?speed=)
+//
+// id is the sessionID the recording was made for; the on-disk filename also
+// carries the sandboxID and creation timestamp, so it's resolved with a
+// glob rather than an exact match.
+type RecordingHandler struct {
+	Dir string
+}
+
+func (h *RecordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/recordings/"), "/")
+	segments := strings.Split(path, "/")
+
+	if len(segments) == 0 || segments[0] == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	castPath, err := h.find(segments[0])
+	if err != nil {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(segments) == 1:
+		http.ServeFile(w, r, castPath)
+	case len(segments) == 2 && segments[1] == "stream":
+		h.stream(w, r, castPath)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// find resolves a sessionID to its recording file on disk. id comes
+// straight off the URL path, so it's glob-escaped before being woven into
+// the pattern: otherwise a path like "*" or "?" would widen the match to
+// every recording in Dir, letting any caller fetch any other session's
+// terminal output.
+func (h *RecordingHandler) find(id string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(h.Dir, fmt.Sprintf("*-%s-*.cast", globEscape(id))))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no recording for session %s", id)
+	}
+	return matches[0], nil
+}
+
+// globEscape backslash-escapes filepath.Glob's metacharacters (*, ?, [, \)
+// so a caller-supplied string is matched literally rather than as a
+// pattern.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stream replays castPath's "o" (output) events over a WebSocket, sleeping
+// between them according to their recorded elapsed time divided by the
+// ?speed= multiplier (default 1.0), so a UI terminal player can render it
+// exactly as it happened without any extra format work.
+func (h *RecordingHandler) stream(w http.ResponseWriter, r *http.Request, castPath string) {
+	speed := 1.0
+	if v, err := strconv.ParseFloat(r.URL.Query().Get("speed"), 64); err == nil && v > 0 {
+		speed = v
+	}
+
+	f, err := os.Open(castPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	if !scanner.Scan() {
+		return // empty file, nothing to replay
+	}
+	// First line is the asciicast header; forward it as-is so a player can
+	// read width/height/env before output starts.
+	if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+		return
+	}
+
+	var lastElapsed float64
+	for scanner.Scan() {
+		var event []json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			continue
+		}
+
+		var elapsed float64
+		var kind string
+		if err := json.Unmarshal(event[0], &elapsed); err != nil {
+			continue
+		}
+		if err := json.Unmarshal(event[1], &kind); err != nil {
+			continue
+		}
+		if kind != "o" {
+			continue
+		}
+
+		if wait := (elapsed - lastElapsed) / speed; wait > 0 {
+			time.Sleep(time.Duration(wait * float64(time.Second)))
+		}
+		lastElapsed = elapsed
+
+		var data string
+		if err := json.Unmarshal(event[2], &data); err != nil {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte(data)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProviderFactory constructs a Provider from backend-specific config (e.g.
+// docker host, firecracker kernel/rootfs paths, warm-pool sizes). cfg is a
+// loosely-typed bag so callers can pass whatever a given backend needs
+// without every backend sharing one rigid options struct.
+type ProviderFactory func(cfg map[string]any) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes a backend available under name for NewProvider to
+// construct. Backends register themselves from an init() in their own
+// package (see docker.init and firecracker.init) so main only needs to
+// import the package for its side effect, never the concrete type.
+//
+// RegisterProvider panics on a duplicate name, matching the conventions of
+// database/sql and image.RegisterFormat: a second registration under the
+// same name is a programming error, not a runtime condition to recover
+// from.
+func RegisterProvider(name string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("rpc: provider %q already registered", name))
+	}
+	registry[name] = factory
+}
+
+// NewProvider constructs the backend registered under name. cfg is passed
+// through to the backend's factory unchanged.
+func NewProvider(name string, cfg map[string]any) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("rpc: unknown provider backend %q (known: %v)", name, ProviderNames())
+	}
+	return factory(cfg)
+}
+
+// ProviderNames returns the currently registered backend names, sorted for
+// stable error messages and logging.
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,7 +1,9 @@
 package rpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io"
@@ -15,6 +17,7 @@ const (
 	DefaultStderrBytes = 1 << 20  // 1MB
 	MaxTimeoutMS       = 120_000  // 2 minutes (set your cap)
 	MaxOutputBytesCap  = 10 << 20 // 10MB cap even if client asks more
+	DefaultFSReadBytes = 1 << 20  // 1MB, same default as exec output
 )
 
 type RPCRequest struct {
@@ -85,12 +88,245 @@ type DeleteResult struct {
 	OK bool `json:"ok"`
 }
 
+type SnapshotParams struct {
+	ID    string `json:"id"`
+	TTLMS int    `json:"ttl_ms,omitempty"`
+}
+
+type SnapshotResult struct {
+	SnapshotID string `json:"snapshot_id"`
+	CreatedAt  string `json:"created_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+type ForkParams struct {
+	SnapshotID string `json:"snapshot_id"`
+	TTLMS      int    `json:"ttl_ms,omitempty"`
+}
+
+type ForkResult struct {
+	ID        string `json:"id"`
+	Template  string `json:"template"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type SnapshotDeleteParams struct {
+	SnapshotID string `json:"snapshot_id"`
+}
+
+type SnapshotDeleteResult struct {
+	OK bool `json:"ok"`
+}
+
+type FSWriteParams struct {
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	ContentB64 string `json:"content_b64"`
+	Mode       int64  `json:"mode,omitempty"`
+}
+
+type FSWriteResult struct {
+	OK bool `json:"ok"`
+}
+
+type FSReadParams struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+}
+
+type FSReadResult struct {
+	ContentB64 string `json:"content_b64"`
+	Truncated  bool   `json:"truncated"`
+}
+
+// FSFileWriteParam is one entry of a sandbox.v1.fs.write_many request.
+type FSFileWriteParam struct {
+	Path       string `json:"path"`
+	ContentB64 string `json:"content_b64"`
+	Mode       int64  `json:"mode,omitempty"`
+}
+
+type FSWriteManyParams struct {
+	ID    string             `json:"id"`
+	Files []FSFileWriteParam `json:"files"`
+}
+
+type FSWriteManyResult struct {
+	OK    bool `json:"ok"`
+	Count int  `json:"count"`
+}
+
+type FSReadManyParams struct {
+	ID       string   `json:"id"`
+	Paths    []string `json:"paths"`
+	MaxBytes int      `json:"max_bytes,omitempty"`
+}
+
+// FSFileReadResult is one entry of a sandbox.v1.fs.read_many response. Err
+// is set instead of ContentB64 when that one path failed, so one bad path
+// doesn't fail the whole batch.
+type FSFileReadResult struct {
+	Path       string `json:"path"`
+	ContentB64 string `json:"content_b64,omitempty"`
+	Truncated  bool   `json:"truncated"`
+	Err        string `json:"err,omitempty"`
+}
+
+type FSReadManyResult struct {
+	Files []FSFileReadResult `json:"files"`
+}
+
+type FSUploadTarParams struct {
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	TarB64 string `json:"tar_b64"`
+}
+
+type FSUploadTarResult struct {
+	OK bool `json:"ok"`
+}
+
+// ExecChunkParams is the payload of a "sandbox.v1.exec.chunk" notification
+// emitted while a sandbox.v1.exec_stream call is in flight.
+type ExecChunkParams struct {
+	ID      string `json:"id"`
+	Stream  string `json:"stream"` // "stdout" | "stderr"
+	DataB64 string `json:"data_b64"`
+	Seq     int64  `json:"seq"`
+}
+
+// ExecChunkNotification is a JSON-RPC notification (no id) carrying one
+// chunk of incremental exec output.
+type ExecChunkNotification struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  ExecChunkParams `json:"params"`
+}
+
+// CreateProgressEvent is the payload of a "sandbox.v1.create.progress"
+// notification emitted while a sandbox.v1.create_stream call is pulling
+// the template's image.
+type CreateProgressEvent struct {
+	Layer    string `json:"layer,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Progress string `json:"progress,omitempty"`
+}
+
+// CreateProgressNotification is a JSON-RPC notification (no id) carrying
+// one line of Docker pull progress.
+type CreateProgressNotification struct {
+	JSONRPC string              `json:"jsonrpc"`
+	Method  string              `json:"method"`
+	Params  CreateProgressEvent `json:"params"`
+}
+
 // ---- Provider interface you’ll implement with Docker ----
 
 type Provider interface {
 	Create(ctx context.Context, template string, ttl time.Duration) (sandboxID string, expiresAt time.Time, err error)
 	Exec(ctx context.Context, sandboxID string, cmd []string, cwd string, env map[string]string, maxOut, maxErr int) (stdout, stderr []byte, exitCode int, timedOut bool, outTrunc, errTrunc bool, duration time.Duration, err error)
+	// ExecStream behaves like Exec but invokes onChunk with each slice of
+	// stdout/stderr as it becomes available instead of buffering it all.
+	// onChunk may be nil, in which case output is discarded past the
+	// truncation limits exactly like Exec.
+	ExecStream(ctx context.Context, sandboxID string, cmd []string, cwd string, env map[string]string, maxOut, maxErr int, onChunk func(stream string, data []byte)) (exitCode int, timedOut bool, outTrunc, errTrunc bool, duration time.Duration, err error)
 	Delete(ctx context.Context, sandboxID string) error
+
+	// Snapshot checkpoints a running sandbox's filesystem and returns an
+	// opaque snapshot ID that Fork can later spawn new sandboxes from.
+	Snapshot(ctx context.Context, sandboxID string, ttl time.Duration) (snapshotID string, createdAt, expiresAt time.Time, err error)
+	// Fork spawns a new sandbox pre-populated with the filesystem state of
+	// a prior Snapshot call, with the same security/resource config as
+	// Create.
+	Fork(ctx context.Context, snapshotID string, ttl time.Duration) (sandboxID, template string, expiresAt time.Time, err error)
+	// DeleteSnapshot releases a snapshot and any backing storage (e.g. the
+	// committed image) it holds.
+	DeleteSnapshot(ctx context.Context, snapshotID string) error
+}
+
+// FileProvider is implemented by backends that support direct archive-based
+// file transfer into and out of a sandbox, independent of Exec. Only the
+// "docker" backend implements it today; handleRequest type-asserts for it
+// the same way main.go does for TerminalProvider, and returns FS_UNSUPPORTED
+// for backends (e.g. "firecracker") that don't.
+type FileProvider interface {
+	WriteFile(ctx context.Context, sandboxID, path string, content []byte, mode int64) error
+	ReadFile(ctx context.Context, sandboxID, path string, maxBytes int) (content []byte, truncated bool, err error)
+	// WriteFiles writes all of files in a single archive round-trip,
+	// dramatically cheaper than one WriteFile call per path for project
+	// uploads.
+	WriteFiles(ctx context.Context, sandboxID string, files []FileWrite) error
+	// ReadFiles reads each of paths independently; a failure on one path is
+	// reported in that entry's Err rather than failing the whole batch.
+	ReadFiles(ctx context.Context, sandboxID string, paths []string, maxBytes int) ([]FileRead, error)
+	// UploadTar extracts a raw tar archive at destPath in one call.
+	UploadTar(ctx context.Context, sandboxID, destPath string, tarData []byte) error
+}
+
+// FileWrite is one file in a WriteFiles batch.
+type FileWrite struct {
+	Path    string
+	Content []byte
+	Mode    int64
+}
+
+// FileRead is one result in a ReadFiles batch.
+type FileRead struct {
+	Path      string
+	Content   []byte
+	Truncated bool
+	Err       string
+}
+
+// FileInfo is one entry in a directory listing, as returned by
+// DockerProvider.ListDir.
+type FileInfo struct {
+	Name  string
+	Path  string
+	IsDir bool
+	Size  int64
+}
+
+// ProgressCreator is implemented by backends that can stream pull-progress
+// events while creating a sandbox whose template image isn't warm yet.
+// Only "docker" implements it; handleRequest/serveCreateStream type-assert
+// for it the same way as FileProvider.
+type ProgressCreator interface {
+	CreateStream(ctx context.Context, template string, ttl time.Duration, onProgress func(layer, status, progress string)) (sandboxID string, expiresAt time.Time, err error)
+}
+
+// HealthReporter is implemented by backends that can report readiness
+// detail beyond "the process is up" — today, per-template pre-warm status.
+// main.go type-asserts for it the same way it does for TerminalProvider.
+type HealthReporter interface {
+	TemplateStatus() map[string]string
+}
+
+// HealthResponse is the JSON body served at /health.
+type HealthResponse struct {
+	Status    string            `json:"status"`
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// SandboxLister is implemented by backends that can enumerate their active
+// sandboxes for operators. Only "docker" implements it today (its sandbox
+// registry is persistent; "firecracker" has no equivalent yet).
+type SandboxLister interface {
+	ListSandboxes(ctx context.Context) ([]SandboxInfo, error)
+}
+
+// SandboxInfo is one entry of a sandbox.v1.list response.
+type SandboxInfo struct {
+	ID        string `json:"id"`
+	Template  string `json:"template"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type ListResult struct {
+	Sandboxes []SandboxInfo `json:"sandboxes"`
 }
 
 // ---- HTTP handler ----
@@ -106,19 +342,211 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		s.serveBatch(w, r, trimmed)
+		return
+	}
+
 	var req RPCRequest
 	if err := json.Unmarshal(body, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
 		writeRPC(w, RPCResponse{JSONRPC: "2.0", Error: rpcErr(-32001, "invalid json-rpc request", "INVALID_PARAMS", false, nil)})
 		return
 	}
 
-	// Dispatch
+	if req.Method == "sandbox.v1.exec_stream" {
+		s.serveExecStream(w, r, req)
+		return
+	}
+
+	if req.Method == "sandbox.v1.create_stream" {
+		s.serveCreateStream(w, r, req)
+		return
+	}
+
+	writeRPC(w, s.handleRequest(r, req))
+}
+
+// serveBatch implements the JSON-RPC 2.0 batch rules: notifications (no
+// "id") produce no response, a mixed batch returns an array of responses
+// in arbitrary order, and an empty batch array is itself an invalid
+// request (returned as a single, non-array error object).
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", Error: rpcErr(-32001, "invalid json-rpc request", "INVALID_PARAMS", false, nil)})
+		return
+	}
+	if len(raw) == 0 {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", Error: rpcErr(-32001, "empty batch", "INVALID_PARAMS", false, nil)})
+		return
+	}
+
+	responses := make([]RPCResponse, 0, len(raw))
+	for _, item := range raw {
+		var req RPCRequest
+		if err := json.Unmarshal(item, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+			responses = append(responses, RPCResponse{JSONRPC: "2.0", Error: rpcErr(-32001, "invalid json-rpc request", "INVALID_PARAMS", false, nil)})
+			continue
+		}
+
+		var idHolder struct {
+			ID *json.RawMessage `json:"id"`
+		}
+		_ = json.Unmarshal(item, &idHolder)
+		isNotification := idHolder.ID == nil
+
+		resp := s.handleRequest(r, req)
+		if isNotification {
+			continue
+		}
+		responses = append(responses, resp)
+	}
+
+	if len(responses) == 0 {
+		// A batch consisting solely of notifications produces no response
+		// body at all per the JSON-RPC 2.0 spec, not an empty array.
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(responses)
+}
+
+// serveExecStream handles sandbox.v1.exec_stream outside the normal
+// single-response dispatch: it keeps the connection open and writes one
+// newline-delimited JSON-RPC notification per output chunk, followed by a
+// terminal response carrying the exec result.
+func (s *Server) serveExecStream(w http.ResponseWriter, r *http.Request, req RPCRequest) {
+	var p ExecParams
+	if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || len(p.Cmd) == 0 {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32006, "streaming not supported", "STREAM_UNSUPPORTED", false, nil)})
+		return
+	}
+
+	timeoutMS, maxOut, maxErr := normalizeExecLimits(p.TimeoutMS, p.MaxStdoutBytes, p.MaxStderrBytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+
+	var seq int64
+	onChunk := func(stream string, data []byte) {
+		seq++
+		_ = enc.Encode(ExecChunkNotification{
+			JSONRPC: "2.0",
+			Method:  "sandbox.v1.exec.chunk",
+			Params: ExecChunkParams{
+				ID:      p.ID,
+				Stream:  stream,
+				DataB64: base64.StdEncoding.EncodeToString(data),
+				Seq:     seq,
+			},
+		})
+		flusher.Flush()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutMS)*time.Millisecond)
+	defer cancel()
+
+	exitCode, timedOut, outTrunc, errTrunc, dur, err := s.P.ExecStream(ctx, p.ID, p.Cmd, p.Cwd, p.Env, maxOut, maxErr, onChunk)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || timedOut {
+			_ = enc.Encode(RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32004, "exec timed out", "EXEC_TIMEOUT", true, map[string]any{"timeout_ms": timeoutMS})})
+			flusher.Flush()
+			return
+		}
+		_ = enc.Encode(RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32005, "exec failed", "EXEC_FAILED", true, map[string]any{"err": err.Error()})})
+		flusher.Flush()
+		return
+	}
+
+	_ = enc.Encode(RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ExecResult{
+		ExitCode:        exitCode,
+		TimedOut:        timedOut,
+		StdoutTruncated: outTrunc,
+		StderrTruncated: errTrunc,
+		DurationMS:      dur.Milliseconds(),
+	}})
+	flusher.Flush()
+}
+
+// serveCreateStream handles sandbox.v1.create_stream the same way
+// serveExecStream handles exec_stream: it keeps the connection open and
+// writes one newline-delimited "sandbox.v1.create.progress" notification
+// per Docker pull status line, followed by a terminal response carrying
+// the create result.
+func (s *Server) serveCreateStream(w http.ResponseWriter, r *http.Request, req RPCRequest) {
+	var p CreateParams
+	if err := json.Unmarshal(req.Params, &p); err != nil || p.Template == "" {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)})
+		return
+	}
+
+	pc, ok := s.P.(ProgressCreator)
+	if !ok {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32014, "create progress streaming not supported", "CREATE_STREAM_UNSUPPORTED", false, nil)})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32006, "streaming not supported", "STREAM_UNSUPPORTED", false, nil)})
+		return
+	}
+
+	ttl := time.Duration(p.TTLMS) * time.Millisecond
+	if p.TTLMS <= 0 {
+		ttl = 10 * time.Minute
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+
+	onProgress := func(layer, status, progress string) {
+		_ = enc.Encode(CreateProgressNotification{
+			JSONRPC: "2.0",
+			Method:  "sandbox.v1.create.progress",
+			Params:  CreateProgressEvent{Layer: layer, Status: status, Progress: progress},
+		})
+		flusher.Flush()
+	}
+
+	id, exp, err := pc.CreateStream(r.Context(), p.Template, ttl, onProgress)
+	if err != nil {
+		_ = enc.Encode(RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32003, "container create failed", "CONTAINER_CREATE_FAILED", true, map[string]any{"err": err.Error()})})
+		flusher.Flush()
+		return
+	}
+
+	now := time.Now().UTC()
+	_ = enc.Encode(RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: CreateResult{
+		ID:        id,
+		Template:  p.Template,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: exp.UTC().Format(time.RFC3339),
+	}})
+	flusher.Flush()
+}
+
+// handleRequest dispatches a single JSON-RPC request and returns the
+// response to send (used directly for single requests and per-item in a
+// batch).
+func (s *Server) handleRequest(r *http.Request, req RPCRequest) RPCResponse {
 	switch req.Method {
 	case "sandbox.v1.create":
 		var p CreateParams
 		if err := json.Unmarshal(req.Params, &p); err != nil || p.Template == "" {
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)})
-			return
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
 		}
 
 		ttl := time.Duration(p.TTLMS) * time.Millisecond
@@ -128,8 +556,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		id, exp, err := s.P.Create(r.Context(), p.Template, ttl)
 		if err != nil {
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32003, "container create failed", "CONTAINER_CREATE_FAILED", true, map[string]any{"err": err.Error()})})
-			return
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32003, "container create failed", "CONTAINER_CREATE_FAILED", true, map[string]any{"err": err.Error()})}
 		}
 
 		now := time.Now().UTC()
@@ -139,38 +566,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			CreatedAt: now.Format(time.RFC3339),
 			ExpiresAt: exp.UTC().Format(time.RFC3339),
 		}
-		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: res})
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: res}
 
 	case "sandbox.v1.exec":
 		var p ExecParams
 		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || len(p.Cmd) == 0 {
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)})
-			return
-		}
-
-		timeoutMS := p.TimeoutMS
-		if timeoutMS <= 0 {
-			timeoutMS = DefaultTimeoutMS
-		}
-		if timeoutMS > MaxTimeoutMS {
-			timeoutMS = MaxTimeoutMS
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
 		}
 
-		maxOut := p.MaxStdoutBytes
-		if maxOut <= 0 {
-			maxOut = DefaultStdoutBytes
-		}
-		if maxOut > MaxOutputBytesCap {
-			maxOut = MaxOutputBytesCap
-		}
-
-		maxErr := p.MaxStderrBytes
-		if maxErr <= 0 {
-			maxErr = DefaultStderrBytes
-		}
-		if maxErr > MaxOutputBytesCap {
-			maxErr = MaxOutputBytesCap
-		}
+		timeoutMS, maxOut, maxErr := normalizeExecLimits(p.TimeoutMS, p.MaxStdoutBytes, p.MaxStderrBytes)
 
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutMS)*time.Millisecond)
 		defer cancel()
@@ -179,11 +583,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			// distinguish not found / timeout / generic
 			if errors.Is(err, context.DeadlineExceeded) || timedOut {
-				writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32004, "exec timed out", "EXEC_TIMEOUT", true, map[string]any{"timeout_ms": timeoutMS})})
-				return
+				return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32004, "exec timed out", "EXEC_TIMEOUT", true, map[string]any{"timeout_ms": timeoutMS})}
 			}
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32005, "exec failed", "EXEC_FAILED", true, map[string]any{"err": err.Error()})})
-			return
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32005, "exec failed", "EXEC_FAILED", true, map[string]any{"err": err.Error()})}
 		}
 
 		res := ExecResult{
@@ -195,25 +597,304 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			StderrTruncated: errTrunc,
 			DurationMS:      dur.Milliseconds(),
 		}
-		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: res})
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: res}
+
+	case "sandbox.v1.exec_stream":
+		// Streaming chunk notifications only make sense on the dedicated,
+		// non-batch HTTP path (see serveExecStream); inside a batch we run
+		// the exec to completion and hand back just the final result.
+		var p ExecParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || len(p.Cmd) == 0 {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+
+		timeoutMS, maxOut, maxErr := normalizeExecLimits(p.TimeoutMS, p.MaxStdoutBytes, p.MaxStderrBytes)
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutMS)*time.Millisecond)
+		defer cancel()
+
+		exitCode, timedOut, outTrunc, errTrunc, dur, err := s.P.ExecStream(ctx, p.ID, p.Cmd, p.Cwd, p.Env, maxOut, maxErr, nil)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || timedOut {
+				return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32004, "exec timed out", "EXEC_TIMEOUT", true, map[string]any{"timeout_ms": timeoutMS})}
+			}
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32005, "exec failed", "EXEC_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ExecResult{
+			ExitCode:        exitCode,
+			TimedOut:        timedOut,
+			StdoutTruncated: outTrunc,
+			StderrTruncated: errTrunc,
+			DurationMS:      dur.Milliseconds(),
+		}}
+
+	case "sandbox.v1.create_stream":
+		// As with exec_stream, progress notifications only make sense on
+		// the dedicated, non-batch HTTP path (see serveCreateStream);
+		// inside a batch we just run the create to completion and hand
+		// back the final result.
+		var p CreateParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.Template == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		pc, ok := s.P.(ProgressCreator)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32014, "create progress streaming not supported", "CREATE_STREAM_UNSUPPORTED", false, nil)}
+		}
+
+		ttl := time.Duration(p.TTLMS) * time.Millisecond
+		if p.TTLMS <= 0 {
+			ttl = 10 * time.Minute
+		}
+
+		id, exp, err := pc.CreateStream(r.Context(), p.Template, ttl, nil)
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32003, "container create failed", "CONTAINER_CREATE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+
+		now := time.Now().UTC()
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: CreateResult{
+			ID:        id,
+			Template:  p.Template,
+			CreatedAt: now.Format(time.RFC3339),
+			ExpiresAt: exp.UTC().Format(time.RFC3339),
+		}}
 
 	case "sandbox.v1.delete":
 		var p DeleteParams
 		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" {
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)})
-			return
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
 		}
 
 		if err := s.P.Delete(r.Context(), p.ID); err != nil {
-			writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32007, "delete failed", "DELETE_FAILED", true, map[string]any{"err": err.Error()})})
-			return
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32007, "delete failed", "DELETE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: DeleteResult{OK: true}}
+
+	case "sandbox.v1.snapshot":
+		var p SnapshotParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+
+		ttl := time.Duration(p.TTLMS) * time.Millisecond
+		if p.TTLMS <= 0 {
+			ttl = 10 * time.Minute
 		}
-		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: DeleteResult{OK: true}})
+
+		snapshotID, createdAt, expiresAt, err := s.P.Snapshot(r.Context(), p.ID, ttl)
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32008, "snapshot failed", "SNAPSHOT_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: SnapshotResult{
+			SnapshotID: snapshotID,
+			CreatedAt:  createdAt.UTC().Format(time.RFC3339),
+			ExpiresAt:  expiresAt.UTC().Format(time.RFC3339),
+		}}
+
+	case "sandbox.v1.fork":
+		var p ForkParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.SnapshotID == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+
+		ttl := time.Duration(p.TTLMS) * time.Millisecond
+		if p.TTLMS <= 0 {
+			ttl = 10 * time.Minute
+		}
+
+		id, template, expiresAt, err := s.P.Fork(r.Context(), p.SnapshotID, ttl)
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32009, "fork failed", "FORK_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+
+		now := time.Now().UTC()
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ForkResult{
+			ID:        id,
+			Template:  template,
+			CreatedAt: now.Format(time.RFC3339),
+			ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+		}}
+
+	case "sandbox.v1.snapshot.delete":
+		var p SnapshotDeleteParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.SnapshotID == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+
+		if err := s.P.DeleteSnapshot(r.Context(), p.SnapshotID); err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32010, "snapshot delete failed", "SNAPSHOT_DELETE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: SnapshotDeleteResult{OK: true}}
+
+	case "sandbox.v1.list":
+		sl, ok := s.P.(SandboxLister)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32015, "listing sandboxes not supported by this backend", "LIST_UNSUPPORTED", false, nil)}
+		}
+
+		sandboxes, err := sl.ListSandboxes(r.Context())
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32016, "list failed", "LIST_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ListResult{Sandboxes: sandboxes}}
+
+	case "sandbox.v1.fs.write":
+		var p FSWriteParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || p.Path == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		fp, ok := s.P.(FileProvider)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32011, "file operations not supported by this backend", "FS_UNSUPPORTED", false, nil)}
+		}
+
+		content, err := base64.StdEncoding.DecodeString(p.ContentB64)
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid content_b64", "INVALID_PARAMS", false, nil)}
+		}
+
+		if err := fp.WriteFile(r.Context(), p.ID, p.Path, content, p.Mode); err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32012, "write failed", "FS_WRITE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: FSWriteResult{OK: true}}
+
+	case "sandbox.v1.fs.read":
+		var p FSReadParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || p.Path == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		fp, ok := s.P.(FileProvider)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32011, "file operations not supported by this backend", "FS_UNSUPPORTED", false, nil)}
+		}
+
+		content, truncated, err := fp.ReadFile(r.Context(), p.ID, p.Path, normalizeFSMaxBytes(p.MaxBytes))
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32013, "read failed", "FS_READ_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: FSReadResult{
+			ContentB64: base64.StdEncoding.EncodeToString(content),
+			Truncated:  truncated,
+		}}
+
+	case "sandbox.v1.fs.write_many":
+		var p FSWriteManyParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || len(p.Files) == 0 {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		fp, ok := s.P.(FileProvider)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32011, "file operations not supported by this backend", "FS_UNSUPPORTED", false, nil)}
+		}
+
+		files := make([]FileWrite, len(p.Files))
+		for i, f := range p.Files {
+			content, err := base64.StdEncoding.DecodeString(f.ContentB64)
+			if err != nil {
+				return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid content_b64", "INVALID_PARAMS", false, map[string]any{"path": f.Path})}
+			}
+			files[i] = FileWrite{Path: f.Path, Content: content, Mode: f.Mode}
+		}
+
+		if err := fp.WriteFiles(r.Context(), p.ID, files); err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32012, "write failed", "FS_WRITE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: FSWriteManyResult{OK: true, Count: len(files)}}
+
+	case "sandbox.v1.fs.read_many":
+		var p FSReadManyParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || len(p.Paths) == 0 {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		fp, ok := s.P.(FileProvider)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32011, "file operations not supported by this backend", "FS_UNSUPPORTED", false, nil)}
+		}
+
+		results, err := fp.ReadFiles(r.Context(), p.ID, p.Paths, normalizeFSMaxBytes(p.MaxBytes))
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32013, "read failed", "FS_READ_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+
+		files := make([]FSFileReadResult, len(results))
+		for i, fr := range results {
+			files[i] = FSFileReadResult{Path: fr.Path, Truncated: fr.Truncated, Err: fr.Err}
+			if fr.Err == "" {
+				files[i].ContentB64 = base64.StdEncoding.EncodeToString(fr.Content)
+			}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: FSReadManyResult{Files: files}}
+
+	case "sandbox.v1.fs.upload_tar":
+		var p FSUploadTarParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" || p.TarB64 == "" {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid params", "INVALID_PARAMS", false, nil)}
+		}
+		fp, ok := s.P.(FileProvider)
+		if !ok {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32011, "file operations not supported by this backend", "FS_UNSUPPORTED", false, nil)}
+		}
+
+		tarData, err := base64.StdEncoding.DecodeString(p.TarB64)
+		if err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32001, "invalid tar_b64", "INVALID_PARAMS", false, nil)}
+		}
+
+		if err := fp.UploadTar(r.Context(), p.ID, p.Path, tarData); err != nil {
+			return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr(-32012, "write failed", "FS_WRITE_FAILED", true, map[string]any{"err": err.Error()})}
+		}
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: FSUploadTarResult{OK: true}}
 
 	default:
 		// JSON-RPC standard “method not found”
-		writeRPC(w, RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &RPCError{Code: -32601, Message: "method not found"}})
+		return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &RPCError{Code: -32601, Message: "method not found"}}
+	}
+}
+
+// normalizeExecLimits clamps a requested timeout/output-size triple to the
+// server's configured defaults and caps. Shared by sandbox.v1.exec and the
+// two sandbox.v1.exec_stream paths so the limits stay identical.
+func normalizeExecLimits(timeoutMS, maxStdoutBytes, maxStderrBytes int) (int, int, int) {
+	if timeoutMS <= 0 {
+		timeoutMS = DefaultTimeoutMS
+	}
+	if timeoutMS > MaxTimeoutMS {
+		timeoutMS = MaxTimeoutMS
+	}
+
+	maxOut := maxStdoutBytes
+	if maxOut <= 0 {
+		maxOut = DefaultStdoutBytes
+	}
+	if maxOut > MaxOutputBytesCap {
+		maxOut = MaxOutputBytesCap
+	}
+
+	maxErr := maxStderrBytes
+	if maxErr <= 0 {
+		maxErr = DefaultStderrBytes
+	}
+	if maxErr > MaxOutputBytesCap {
+		maxErr = MaxOutputBytesCap
+	}
+
+	return timeoutMS, maxOut, maxErr
+}
+
+// normalizeFSMaxBytes applies the same default/cap treatment to a
+// requested fs.read max_bytes that normalizeExecLimits applies to exec
+// output limits.
+func normalizeFSMaxBytes(maxBytes int) int {
+	if maxBytes <= 0 {
+		maxBytes = DefaultFSReadBytes
+	}
+	if maxBytes > MaxOutputBytesCap {
+		maxBytes = MaxOutputBytesCap
 	}
+	return maxBytes
 }
 
 func rpcErr(code int, msg, typ string, retryable bool, details any) *RPCError {
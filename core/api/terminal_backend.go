@@ -0,0 +1,154 @@
+package rpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ResizeFunc resizes an already-started terminal to cols x rows.
+type ResizeFunc func(cols, rows uint16) error
+
+// TerminalBackend starts an interactive shell for a sandbox, wherever it
+// actually runs, and hands back a single pipe multiplexing its stdio plus a
+// way to resize it. DockerTerminalBackend execs into a container; the SSH
+// backend (core/ssh) dials a remote host instead — TerminalHandler and
+// ExecSessionManager don't need to know which one they're talking to.
+type TerminalBackend interface {
+	Start(ctx context.Context, sandboxID string, cols, rows uint16) (io.ReadWriteCloser, ResizeFunc, error)
+}
+
+// TerminalBackendProvider resolves the TerminalBackend to use for a given
+// sandbox, so a single provider can serve a mix of local (Docker exec) and
+// remote (SSH) sandboxes transparently to the browser: the WebSocket URL
+// and control-frame protocol stay identical either way. A provider that
+// doesn't implement this (e.g. one with no notion of remote targets) gets
+// DockerTerminalBackend as the default, same as before this existed.
+type TerminalBackendProvider interface {
+	TerminalBackend(sandboxID string) (TerminalBackend, error)
+}
+
+// NonTTYSession is what NonTTYBackend.StartNonTTY hands back: stdout and
+// stderr kept separate (no PTY merging them into one stream), plus a Wait
+// that blocks until the command exits and returns its real exit code. This
+// is the shape a "run this command and stream results" caller needs,
+// rather than an interactive shell's single combined stream and no exit
+// status.
+type NonTTYSession struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+	Stderr io.Reader
+	Wait   func(ctx context.Context) (int, error)
+}
+
+// NonTTYBackend is an optional TerminalBackend capability: running a
+// command to completion with stdout/stderr demultiplexed and a real exit
+// code, for structured execution rather than an interactive shell.
+// DockerTerminalBackend implements it by demuxing Docker's own multiplexed
+// attach stream; a backend with no equivalent (e.g. SSH's PTY-only
+// session) simply doesn't, and callers type-assert for it.
+type NonTTYBackend interface {
+	StartNonTTY(ctx context.Context, sandboxID string, cmd []string) (*NonTTYSession, error)
+}
+
+// readWriteCloser adapts a separate reader/writer/closer triple (as Docker's
+// hijacked exec connections come in) into a single io.ReadWriteCloser, the
+// shape every TerminalBackend hands back.
+type readWriteCloser struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// DockerTerminalBackend is the default TerminalBackend: it execs a shell in
+// an existing container via the Docker exec API, the same mechanism
+// TerminalHandler used before backend selection existed.
+type DockerTerminalBackend struct {
+	Cli         *client.Client
+	ContainerID string
+}
+
+// Start implements TerminalBackend.
+func (b *DockerTerminalBackend) Start(ctx context.Context, sandboxID string, cols, rows uint16) (io.ReadWriteCloser, ResizeFunc, error) {
+	execConfig := container.ExecOptions{
+		Cmd:          []string{"/bin/sh"},
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	execID, err := b.Cli.ContainerExecCreate(ctx, b.ContainerID, execConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// ContainerExecAttach itself starts the process; ContainerExecStart is
+	// only for the Detach:true path and rejects an already-running exec
+	// with a 409 Conflict.
+	resp, err := b.Cli.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{Tty: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := b.Cli.ContainerExecResize(ctx, execID.ID, container.ResizeOptions{Height: uint(rows), Width: uint(cols)}); err != nil {
+		resp.Close()
+		return nil, nil, err
+	}
+
+	conn := &readWriteCloser{Reader: resp.Reader, Writer: resp.Conn, Closer: resp.Conn}
+	resize := func(cols, rows uint16) error {
+		return b.Cli.ContainerExecResize(context.Background(), execID.ID, container.ResizeOptions{Height: uint(rows), Width: uint(cols)})
+	}
+	return conn, resize, nil
+}
+
+// StartNonTTY implements NonTTYBackend by running cmd without a PTY, so
+// Docker multiplexes stdout and stderr onto the one attach stream the same
+// way it does for Provider.Exec; stdcopy.StdCopy demuxes it back into two
+// readers.
+func (b *DockerTerminalBackend) StartNonTTY(ctx context.Context, sandboxID string, cmd []string) (*NonTTYSession, error) {
+	if len(cmd) == 0 {
+		cmd = []string{"/bin/sh"}
+	}
+
+	execID, err := b.Cli.ContainerExecCreate(ctx, b.ContainerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ContainerExecAttach itself starts the process; a follow-up
+	// ContainerExecStart would target an already-running exec and the
+	// daemon rejects it with a 409 Conflict.
+	resp, err := b.Cli.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(outW, errW, resp.Reader)
+		outW.CloseWithError(err)
+		errW.CloseWithError(err)
+	}()
+
+	wait := func(ctx context.Context) (int, error) {
+		defer resp.Close()
+		inspect, err := b.Cli.ContainerExecInspect(ctx, execID.ID)
+		if err != nil {
+			return 0, err
+		}
+		return inspect.ExitCode, nil
+	}
+
+	return &NonTTYSession{Stdin: resp.Conn, Stdout: outR, Stderr: errR, Wait: wait}, nil
+}
@@ -2,17 +2,53 @@ package rpc
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/gorilla/websocket"
 )
 
+// defaultCols/defaultRows match the PTY size Docker itself defaults to when
+// none is requested, so clients that don't pass cols/rows see no change in
+// behavior.
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// controlFrame is the JSON control-frame protocol accepted on the
+// WebSocket's inbound side. A "resize" frame adjusts the PTY size; a "data"
+// frame carries terminal input. Messages that don't parse as a controlFrame
+// (e.g. plain bytes from older clients) are treated as raw stdin, unchanged,
+// for backward compatibility.
+type controlFrame struct {
+	Type    string `json:"type"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+// ptySize parses cols/rows query parameters (as used by the SSH terminal),
+// falling back to defaultCols/defaultRows when absent or invalid.
+func ptySize(r *http.Request) (cols, rows uint16) {
+	cols, rows = defaultCols, defaultRows
+	if v, err := strconv.ParseUint(r.URL.Query().Get("cols"), 10, 16); err == nil && v > 0 {
+		cols = uint16(v)
+	}
+	if v, err := strconv.ParseUint(r.URL.Query().Get("rows"), 10, 16); err == nil && v > 0 {
+		rows = uint16(v)
+	}
+	return cols, rows
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -27,9 +63,19 @@ type TerminalProvider interface {
 	GetClient() *client.Client
 }
 
-// TerminalHandler handles WebSocket terminal connections
+// TerminalHandler handles WebSocket terminal connections. It's a thin
+// convenience wrapper around ExecSessionManager: each connect creates a
+// fresh, ephemeral exec session and attaches to it immediately, for clients
+// that don't need to reattach across reconnects. Clients that do (mobile
+// network hiccups, page refreshes) should use POST /exec/{sandboxID} and
+// GET /exec/attach/{sessionID} instead.
+//
+// P resolves the TerminalBackend to start the shell on, per sandbox, so
+// the WebSocket URL and control-frame protocol are identical whether
+// sandboxID is a local Docker container or a remote SSH host.
 type TerminalHandler struct {
-	P TerminalProvider
+	P   TerminalBackendProvider
+	Mgr *ExecSessionManager
 }
 
 // ServeHTTP handles WebSocket upgrade and terminal streaming
@@ -44,13 +90,29 @@ func (h *TerminalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get container ID
-	containerID, err := h.P.GetContainerID(sandboxID)
+	backend, err := h.P.TerminalBackend(sandboxID)
 	if err != nil {
 		http.Error(w, "sandbox not found", http.StatusNotFound)
 		return
 	}
 
+	// ?tty=0 switches from an interactive shell to running a single command
+	// to completion with stdout/stderr kept separate and a real exit code,
+	// for callers that want "run X in this sandbox" rather than a terminal.
+	if r.URL.Query().Get("tty") == "0" {
+		h.serveNonTTY(w, r, backend, sandboxID)
+		return
+	}
+
+	cols, rows := ptySize(r)
+	record := r.URL.Query().Get("record") == "1"
+	session, err := h.Mgr.CreateFromBackend(context.Background(), backend, sandboxID, cols, rows, record)
+	if err != nil {
+		log.Printf("Exec session create failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -59,92 +121,286 @@ func (h *TerminalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// Create exec with TTY
-	cli := h.P.GetClient()
-	ctx := context.Background()
+	attachSession(conn, session)
+}
+
+// Non-tty WebSocket frames are prefixed with a single byte identifying the
+// channel they carry, so a caller streaming a command's output can tell
+// stdout from stderr and learn its exit code, none of which an interactive
+// PTY's single merged stream can express.
+const (
+	frameStdout = 0x01
+	frameStderr = 0x02
+	frameExit   = 0x03
+)
 
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"/bin/sh"},
-		Tty:          true,
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
+// nonTTYCmd parses the "cmd" query parameter (a shell command line) into an
+// argv for /bin/sh -c, falling back to a bare shell if none is given.
+func nonTTYCmd(r *http.Request) []string {
+	cmd := r.URL.Query().Get("cmd")
+	if cmd == "" {
+		return []string{"/bin/sh"}
 	}
+	return []string{"/bin/sh", "-c", cmd}
+}
 
-	execID, err := cli.ContainerExecCreate(ctx, containerID, execConfig)
-	if err != nil {
-		log.Printf("Exec create failed: %v", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()))
+// serveNonTTY runs a single command to completion via backend's
+// NonTTYBackend capability, forwarding its demultiplexed stdout/stderr to
+// conn as prefixed frames and finishing with a frameExit frame carrying the
+// real exit code. Unlike attachSession there's no reattach or scrollback:
+// this is a one-shot run, not a long-lived session in ExecSessionManager.
+func (h *TerminalHandler) serveNonTTY(w http.ResponseWriter, r *http.Request, backend TerminalBackend, sandboxID string) {
+	runner, ok := backend.(NonTTYBackend)
+	if !ok {
+		http.Error(w, "backend does not support non-tty mode", http.StatusNotImplemented)
 		return
 	}
 
-	// Attach to exec with TTY
-	resp, err := cli.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{
-		Tty: true,
-	})
+	sess, err := runner.StartNonTTY(r.Context(), sandboxID, nonTTYCmd(r))
 	if err != nil {
-		log.Printf("Exec attach failed: %v", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()))
+		log.Printf("Non-tty exec start failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer resp.Close()
 
-	// Start the exec
-	err = cli.ContainerExecStart(ctx, execID.ID, container.ExecStartOptions{Tty: true})
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Exec start failed: %v", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()))
+		log.Printf("WebSocket upgrade failed: %v", err)
+		sess.Stdin.Close()
 		return
 	}
+	defer conn.Close()
 
-	// Create done channel for cleanup
-	done := make(chan struct{})
+	var writeMu sync.Mutex
+	writeFrame := func(prefix byte, p []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{prefix}, p...))
+	}
 
-	// Container stdout → WebSocket
-	go func() {
-		defer close(done)
-		buf := make([]byte, 1024)
+	pump := func(prefix byte, src io.Reader) {
+		buf := make([]byte, 4096)
 		for {
-			n, err := resp.Reader.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Read from container failed: %v", err)
-				}
-				return
-			}
+			n, err := src.Read(buf)
 			if n > 0 {
-				err = conn.WriteMessage(websocket.BinaryMessage, buf[:n])
-				if err != nil {
-					log.Printf("Write to WebSocket failed: %v", err)
+				if werr := writeFrame(prefix, buf[:n]); werr != nil {
 					return
 				}
 			}
+			if err != nil {
+				return
+			}
 		}
-	}()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); pump(frameStdout, sess.Stdout) }()
+	go func() { defer wg.Done(); pump(frameStderr, sess.Stderr) }()
 
-	// WebSocket → Container stdin
 	go func() {
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket read error: %v", err)
-				}
 				return
 			}
-			_, err = resp.Conn.Write(message)
-			if err != nil {
-				log.Printf("Write to container failed: %v", err)
+			if _, err := sess.Stdin.Write(message); err != nil {
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	code, err := sess.Wait(r.Context())
+	if err != nil {
+		log.Printf("Non-tty exec wait failed: %v", err)
+		code = -1
+	}
+	// Encoded via int32 so a negative code (the sentinel used above when
+	// Wait itself failed) round-trips as negative for a client decoding
+	// the 4 bytes as signed, rather than wrapping into a huge unsigned
+	// value indistinguishable from a real exit status.
+	exitFrame := make([]byte, 4)
+	binary.BigEndian.PutUint32(exitFrame, uint32(int32(code)))
+	if err := writeFrame(frameExit, exitFrame); err != nil {
+		log.Printf("Write exit frame failed: %v", err)
+	}
+}
+
+// attachSession streams an ExecSession's buffered scrollback plus live
+// output to conn, and forwards conn's input (raw bytes or controlFrame
+// JSON) to the session's stdin, until either side disconnects. The session
+// itself is left running: detaching here doesn't kill it.
+func attachSession(conn *websocket.Conn, session *ExecSession) {
+	scrollback, outputCh, subID := session.subscribe()
+	defer session.unsubscribe(subID)
+
+	if len(scrollback) > 0 {
+		if err := conn.WriteMessage(websocket.BinaryMessage, scrollback); err != nil {
+			log.Printf("Write scrollback to WebSocket failed: %v", err)
+			return
+		}
+	}
+
+	done := make(chan struct{})
+
+	// Session output → WebSocket
+	go func() {
+		defer close(done)
+		for chunk := range outputCh {
+			if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+				log.Printf("Write to WebSocket failed: %v", err)
 				return
 			}
 		}
 	}()
 
-	// Handle resize messages (optional, for terminal resize)
 	conn.SetPingHandler(func(appData string) error {
 		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
 	})
 
-	// Wait for done
+	// WebSocket → session stdin, with an optional JSON control-frame
+	// protocol layered on top so the browser can push resize events
+	// alongside keystrokes on the same connection.
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			break
+		}
+
+		input := message
+		var cf controlFrame
+		if json.Unmarshal(message, &cf) == nil && cf.Type != "" {
+			switch cf.Type {
+			case "resize":
+				if cf.Cols > 0 && cf.Rows > 0 {
+					if err := session.Resize(context.Background(), cf.Cols, cf.Rows); err != nil {
+						log.Printf("Exec resize failed: %v", err)
+					}
+				}
+				continue
+			case "data":
+				input = []byte(cf.Payload)
+			default:
+				continue
+			}
+		}
+
+		if _, err := session.Write(input); err != nil {
+			log.Printf("Write to session failed: %v", err)
+			break
+		}
+	}
+
 	<-done
 }
+
+// ExecHandler exposes ExecSessionManager over REST plus a WebSocket attach
+// endpoint:
+//
+//	POST   /exec/{sandboxID}      create a session, returns {sessionID}
+//	GET    /exec/attach/{id}      upgrade to WebSocket and attach
+//	GET    /exec/{id}             poll running/exit status
+//	DELETE /exec/{id}             kill the session
+type ExecHandler struct {
+	P   TerminalProvider
+	Mgr *ExecSessionManager
+}
+
+func (h *ExecHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/exec/"), "/")
+	segments := strings.Split(path, "/")
+
+	if len(segments) == 2 && segments[0] == "attach" && r.Method == http.MethodGet {
+		h.attach(w, r, segments[1])
+		return
+	}
+
+	if len(segments) != 1 || segments[0] == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.create(w, r, segments[0])
+	case http.MethodGet:
+		h.status(w, segments[0])
+	case http.MethodDelete:
+		h.kill(w, segments[0])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ExecHandler) create(w http.ResponseWriter, r *http.Request, sandboxID string) {
+	containerID, err := h.P.GetContainerID(sandboxID)
+	if err != nil {
+		http.Error(w, "sandbox not found", http.StatusNotFound)
+		return
+	}
+
+	var req ExecCreateRequest
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := h.Mgr.Create(r.Context(), h.P.GetClient(), sandboxID, containerID, req)
+	if err != nil {
+		log.Printf("Exec session create failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ExecCreateResponse{SessionID: session.ID})
+}
+
+func (h *ExecHandler) attach(w http.ResponseWriter, r *http.Request, sessionID string) {
+	session := h.Mgr.Get(sessionID)
+	if session == nil {
+		http.Error(w, "exec session not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	attachSession(conn, session)
+}
+
+func (h *ExecHandler) status(w http.ResponseWriter, sessionID string) {
+	session := h.Mgr.Get(sessionID)
+	if session == nil {
+		http.Error(w, "exec session not found", http.StatusNotFound)
+		return
+	}
+
+	status, err := session.Status(context.Background())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func (h *ExecHandler) kill(w http.ResponseWriter, sessionID string) {
+	if err := h.Mgr.Delete(sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,33 +2,79 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 
-	"sandbox/core/docker"
 	rpc "sandbox/core/api"
+	_ "sandbox/core/docker"      // registers the "docker" backend
+	_ "sandbox/core/firecracker" // registers the "firecracker" backend
 )
 
+// backendName picks the provider backend to run, defaulting to "docker"
+// for backward compatibility. Other registered backends ("firecracker",
+// and whatever third parties add via rpc.RegisterProvider) are selected
+// with SANDBOX_BACKEND.
+func backendName() string {
+	if b := os.Getenv("SANDBOX_BACKEND"); b != "" {
+		return b
+	}
+	return "docker"
+}
+
+// recordingsDir picks where asciicast v2 session recordings are written,
+// defaulting to a local "recordings" directory.
+func recordingsDir() string {
+	if d := os.Getenv("SANDBOX_RECORDINGS_DIR"); d != "" {
+		return d
+	}
+	return "recordings"
+}
+
 func main() {
-	// Create Docker provider
-	provider, err := docker.NewDockerProvider()
+	backend := backendName()
+	provider, err := rpc.NewProvider(backend, nil)
 	if err != nil {
-		log.Fatalf("Failed to create provider: %v", err)
+		log.Fatalf("Failed to create %s provider: %v", backend, err)
 	}
 
 	// Create RPC server with provider
 	server := &rpc.Server{P: provider}
+	http.Handle("/rpc", server)
 
-	// Create terminal handler for WebSocket
-	terminal := &rpc.TerminalHandler{P: provider}
+	// The WebSocket terminal and the exec session API only make sense for
+	// backends that expose a Docker client (today: "docker"); other
+	// backends simply don't mount them.
+	if term, ok := provider.(rpc.TerminalProvider); ok {
+		dir := recordingsDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatalf("Failed to create recordings dir %s: %v", dir, err)
+		}
+		recordAll := os.Getenv("SANDBOX_RECORD_ALL") == "1"
 
-	// Mount handlers
-	http.Handle("/rpc", server)
-	http.Handle("/terminal/", terminal)
+		execMgr := rpc.NewExecSessionManager(dir, recordAll)
+		http.Handle("/exec/", &rpc.ExecHandler{P: term, Mgr: execMgr})
+		http.Handle("/recordings/", &rpc.RecordingHandler{Dir: dir})
+
+		if backendProvider, ok := provider.(rpc.TerminalBackendProvider); ok {
+			http.Handle("/terminal/", &rpc.TerminalHandler{P: backendProvider, Mgr: execMgr})
+		} else {
+			log.Printf("backend %s does not resolve per-sandbox terminal backends, /terminal disabled", backend)
+		}
+	} else {
+		log.Printf("backend %s does not support /terminal or /exec, skipping", backend)
+	}
 
-	// Health check
+	// Health check, enriched with per-template pre-warm status for backends
+	// (today: "docker") that track it.
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok"))
+		resp := rpc.HealthResponse{Status: "ok"}
+		if hr, ok := provider.(rpc.HealthReporter); ok {
+			resp.Templates = hr.TemplateStatus()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
 	})
 
 	addr := ":8080"
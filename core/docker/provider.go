@@ -2,15 +2,20 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
@@ -22,9 +27,18 @@ import (
 // DockerProvider implements the Provider interface from rpc.go
 type DockerProvider struct {
 	client     *client.Client
-	sandboxes  map[string]*Sandbox // track active sandboxes
+	sandboxes  map[string]*Sandbox  // track active sandboxes
+	snapshots  map[string]*Snapshot // track checkpointed sandbox images
 	mu         sync.RWMutex
 	gcInterval time.Duration
+
+	templateStatusMu sync.RWMutex
+	templateStatus   map[string]string // pre-warm readiness per template, reported via /health
+
+	warmPoolsMu sync.Mutex
+	warmPools   map[string]*warmPool // per-template pool of pre-created stopped containers
+
+	store SandboxStore // persists sandboxes across restarts; see reconcile
 }
 
 type Sandbox struct {
@@ -35,6 +49,16 @@ type Sandbox struct {
 	ExpiresAt   time.Time
 }
 
+// Snapshot is a committed image of a sandbox's filesystem at a point in
+// time, produced by sandbox.v1.snapshot and consumed by sandbox.v1.fork.
+type Snapshot struct {
+	ID        string
+	Image     string
+	Template  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
 // Template configs - which image to use for each template
 var templates = map[string]string{
 	"python": "python:3.11-slim",
@@ -42,7 +66,95 @@ var templates = map[string]string{
 	"go":     "golang:1.22-alpine",
 }
 
-func NewDockerProvider() (*DockerProvider, error) {
+// Pre-warm readiness states reported per template via /health.
+const (
+	statusPending = "pending"
+	statusPulling = "pulling"
+	statusReady   = "ready"
+	statusError   = "error"
+)
+
+// managedLabel marks every container DockerProvider creates (sandboxes and
+// warm-pool spares alike) so reconcile and operators (`docker ps --filter
+// label=capsule.managed=true`) can both find them without relying on name
+// prefixes.
+const managedLabel = "capsule.managed"
+
+// defaultStorePath is where the sandbox store lives if Config doesn't say
+// otherwise, matching firecracker.Config's /var/lib/capsule convention.
+const defaultStorePath = "/var/lib/capsule/sandboxes.db"
+
+// WarmPoolConfig controls how many stopped containers DockerProvider keeps
+// pre-created for one template, how often it tops the pool back up, and
+// how long an idle pooled container may sit before it's reclaimed.
+type WarmPoolConfig struct {
+	Size           int
+	RefillInterval time.Duration
+	TTL            time.Duration
+}
+
+// Config configures NewDockerProvider: per-template warm-pool sizing (a
+// template absent from WarmPool gets no pool; Create always falls back to
+// a fresh ContainerCreate) and where the sandbox store persists.
+type Config struct {
+	WarmPool map[string]WarmPoolConfig
+	// StorePath is the BoltDB file used to persist sandbox records across
+	// restarts. Defaults to defaultStorePath.
+	StorePath string
+	// Store overrides the default BoltStore, e.g. for tests. Most callers
+	// should leave this nil and use StorePath.
+	Store SandboxStore
+}
+
+// ConfigFromMap reads a Config out of the loosely-typed cfg map the
+// registry passes to every backend factory, the same convention
+// firecracker.ConfigFromMap uses.
+func ConfigFromMap(cfg map[string]any) Config {
+	c := Config{WarmPool: map[string]WarmPoolConfig{}, StorePath: defaultStorePath}
+	if path, ok := cfg["store_path"].(string); ok && path != "" {
+		c.StorePath = path
+	}
+
+	raw, _ := cfg["warm_pool"].(map[string]any)
+	for template, v := range raw {
+		wc := WarmPoolConfig{RefillInterval: 5 * time.Second, TTL: 10 * time.Minute}
+		m, _ := v.(map[string]any)
+		if sz, ok := m["size"].(int); ok && sz > 0 {
+			wc.Size = sz
+		}
+		if ms, ok := m["refill_interval_ms"].(int); ok && ms > 0 {
+			wc.RefillInterval = time.Duration(ms) * time.Millisecond
+		}
+		if ms, ok := m["ttl_ms"].(int); ok && ms > 0 {
+			wc.TTL = time.Duration(ms) * time.Millisecond
+		}
+		c.WarmPool[template] = wc
+	}
+	return c
+}
+
+// warmContainer is a pre-created, stopped container sitting in a
+// template's warm pool, ready for Create/CreateStream to start instantly
+// instead of paying ContainerCreate's cost on the hot path.
+type warmContainer struct {
+	ContainerID string
+	CreatedAt   time.Time
+}
+
+// warmPool is one template's warm-container queue plus the config
+// controlling its size/refill/TTL.
+type warmPool struct {
+	cfg   WarmPoolConfig
+	items []warmContainer
+}
+
+func init() {
+	rpc.RegisterProvider("docker", func(cfg map[string]any) (rpc.Provider, error) {
+		return NewDockerProvider(ConfigFromMap(cfg))
+	})
+}
+
+func NewDockerProvider(cfg Config) (*DockerProvider, error) {
 	// Connect to Docker daemon via unix socket
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -58,18 +170,196 @@ func NewDockerProvider() (*DockerProvider, error) {
 		return nil, fmt.Errorf("failed to ping docker: %w", err)
 	}
 
+	store := cfg.Store
+	if store == nil {
+		path := cfg.StorePath
+		if path == "" {
+			path = defaultStorePath
+		}
+		store, err = NewBoltStore(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	p := &DockerProvider{
-		client:     cli,
-		sandboxes:  make(map[string]*Sandbox),
-		gcInterval: 30 * time.Second,
+		client:         cli,
+		sandboxes:      make(map[string]*Sandbox),
+		snapshots:      make(map[string]*Snapshot),
+		gcInterval:     30 * time.Second,
+		templateStatus: make(map[string]string, len(templates)),
+		warmPools:      make(map[string]*warmPool),
+		store:          store,
+	}
+
+	// Reconcile the in-memory map against the persistent store and what's
+	// actually running in Docker before anything else touches p.sandboxes,
+	// so a restart picks up pre-existing sandboxes instead of orphaning
+	// their containers.
+	if err := p.reconcile(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reconcile sandbox store: %w", err)
+	}
+
+	for template := range templates {
+		p.templateStatus[template] = statusPending
+		if wc, ok := cfg.WarmPool[template]; ok {
+			pool := &warmPool{cfg: wc}
+			p.warmPools[template] = pool
+			go p.warmPoolLoop(template)
+		}
 	}
 
 	// Start background cleanup of expired sandboxes
 	go p.gcLoop()
+	// Pull every template's image up front so the first real Create for it
+	// doesn't block a client on a cold pull.
+	go p.prewarmImages(context.Background())
 
 	return p, nil
 }
 
+// reconcile runs once at startup: it lists every container Docker reports
+// with the "sandbox-" name prefix, cross-references the persistent store,
+// removes containers the store doesn't know about (crashed between
+// ContainerCreate and store.Put) and store entries whose container is
+// gone (killed out from under us), and rebuilds the in-memory sandboxes
+// map from what survives both checks. This is what lets the server
+// restart without leaking (or silently losing track of) running
+// sandboxes. It also calls reconcileSnapshots to do the equivalent for
+// committed snapshot images.
+func (p *DockerProvider) reconcile(ctx context.Context) error {
+	stored, err := p.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list sandbox store: %w", err)
+	}
+	storedByContainer := make(map[string]*Sandbox, len(stored))
+	for _, sb := range stored {
+		storedByContainer[sb.ContainerID] = sb
+	}
+
+	// Every container we create (sandboxes and warm-pool spares alike)
+	// carries managedLabel; a warm-pool spare that isn't yet in the store
+	// is treated as an orphan below, but that's fine - it's just a stopped
+	// container with no content worth keeping, and fillWarmPool tops the
+	// pool back up on its own schedule.
+	containers, err := p.client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=true")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	live := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		live[c.ID] = true
+	}
+
+	for containerID, sb := range storedByContainer {
+		if !live[containerID] {
+			_ = p.store.Delete(sb.ID)
+			continue
+		}
+		p.sandboxes[sb.ID] = sb
+	}
+
+	for _, c := range containers {
+		if storedByContainer[c.ID] == nil {
+			_ = p.client.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true})
+		}
+	}
+
+	return p.reconcileSnapshots(ctx)
+}
+
+// reconcileSnapshots is reconcile's counterpart for committed snapshot
+// images: it rebuilds p.snapshots from the persistent store (if the store
+// supports it; most callers use BoltStore, which does) and drops any
+// record whose image Docker no longer has, the same "survives both
+// checks" rule reconcile applies to sandboxes/containers. Without this, a
+// restart would start p.snapshots empty and leak every committed image
+// that outlives the process, since nothing would ever expire or delete
+// them again.
+func (p *DockerProvider) reconcileSnapshots(ctx context.Context) error {
+	ss, ok := p.store.(SnapshotStore)
+	if !ok {
+		return nil
+	}
+
+	stored, err := ss.ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshot store: %w", err)
+	}
+
+	for _, snap := range stored {
+		if _, _, err := p.client.ImageInspectWithRaw(ctx, snap.Image); err != nil {
+			_ = ss.DeleteSnapshot(snap.ID)
+			continue
+		}
+		p.snapshots[snap.ID] = snap
+	}
+
+	return nil
+}
+
+// ListSandboxes implements rpc.SandboxLister.ListSandboxes, used by
+// sandbox.v1.list to give operators visibility into active sandboxes.
+func (p *DockerProvider) ListSandboxes(ctx context.Context) ([]rpc.SandboxInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make([]rpc.SandboxInfo, 0, len(p.sandboxes))
+	for _, sb := range p.sandboxes {
+		out = append(out, rpc.SandboxInfo{
+			ID:        sb.ID,
+			Template:  sb.Template,
+			CreatedAt: sb.CreatedAt.UTC().Format(time.RFC3339),
+			ExpiresAt: sb.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return out, nil
+}
+
+// TemplateStatus reports each template's pre-warm readiness ("pending",
+// "pulling", "ready", or "error"), used by the /health endpoint.
+func (p *DockerProvider) TemplateStatus() map[string]string {
+	p.templateStatusMu.RLock()
+	defer p.templateStatusMu.RUnlock()
+
+	out := make(map[string]string, len(p.templateStatus))
+	for template, status := range p.templateStatus {
+		out[template] = status
+	}
+	return out
+}
+
+func (p *DockerProvider) setTemplateStatus(template, status string) {
+	p.templateStatusMu.Lock()
+	p.templateStatus[template] = status
+	p.templateStatusMu.Unlock()
+}
+
+// prewarmImages pulls every template's image at startup (if not already
+// present) and tops up that template's warm pool once the image lands,
+// reporting progress through TemplateStatus the whole way.
+func (p *DockerProvider) prewarmImages(ctx context.Context) {
+	for template, img := range templates {
+		p.setTemplateStatus(template, statusPulling)
+
+		if _, _, err := p.client.ImageInspectWithRaw(ctx, img); err != nil {
+			reader, err := p.client.ImagePull(ctx, img, image.PullOptions{})
+			if err != nil {
+				p.setTemplateStatus(template, statusError)
+				continue
+			}
+			_, _ = io.Copy(io.Discard, reader)
+			reader.Close()
+		}
+
+		p.setTemplateStatus(template, statusReady)
+		p.fillWarmPool(ctx, template)
+	}
+}
+
 // Create implements Provider.Create
 func (p *DockerProvider) Create(ctx context.Context, template string, ttl time.Duration) (string, time.Time, error) {
 	// Validate template
@@ -78,6 +368,10 @@ func (p *DockerProvider) Create(ctx context.Context, template string, ttl time.D
 		return "", time.Time{}, fmt.Errorf("unknown template: %s", template)
 	}
 
+	if sandboxID, expiresAt, ok := p.takeWarm(template, ttl); ok {
+		return sandboxID, expiresAt, nil
+	}
+
 	// Pull image if not exists (could be slow first time)
 	_, _, err := p.client.ImageInspectWithRaw(ctx, img)
 	if err != nil {
@@ -91,10 +385,97 @@ func (p *DockerProvider) Create(ctx context.Context, template string, ttl time.D
 		_, _ = io.Copy(io.Discard, reader)
 	}
 
-	// Generate sandbox ID
+	return p.createFromImage(ctx, img, template, ttl)
+}
+
+// CreateStream implements rpc.ProgressCreator.CreateStream. It behaves
+// exactly like Create, except that a cold image pull decodes Docker's pull
+// JSON status lines and forwards one onProgress event per line instead of
+// silently blocking on io.Copy(io.Discard, ...).
+func (p *DockerProvider) CreateStream(ctx context.Context, template string, ttl time.Duration, onProgress func(layer, status, progress string)) (string, time.Time, error) {
+	img, ok := templates[template]
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("unknown template: %s", template)
+	}
+
+	if sandboxID, expiresAt, ok := p.takeWarm(template, ttl); ok {
+		return sandboxID, expiresAt, nil
+	}
+
+	if _, _, err := p.client.ImageInspectWithRaw(ctx, img); err != nil {
+		reader, err := p.client.ImagePull(ctx, img, image.PullOptions{})
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to pull image %s: %w", img, err)
+		}
+		defer reader.Close()
+
+		dec := json.NewDecoder(reader)
+		for {
+			var line struct {
+				Status   string `json:"status"`
+				ID       string `json:"id"`
+				Progress string `json:"progress"`
+			}
+			if err := dec.Decode(&line); err != nil {
+				break
+			}
+			if onProgress != nil {
+				onProgress(line.ID, line.Status, line.Progress)
+			}
+		}
+	}
+
+	return p.createFromImage(ctx, img, template, ttl)
+}
+
+// createFromImage does the actual container-create-and-track work shared
+// by Create (template -> image) and Fork (snapshot -> committed image);
+// the caller is responsible for making sure img already exists locally.
+func (p *DockerProvider) createFromImage(ctx context.Context, img, template string, ttl time.Duration) (string, time.Time, error) {
 	sandboxID := uuid.New().String()[:8]
 
-	// Create container with security constraints
+	containerID, err := p.newContainer(ctx, img, "sandbox-"+sandboxID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Start the container
+	if err := p.client.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		// Cleanup on failure
+		_ = p.client.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
+		return "", time.Time{}, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	sb := &Sandbox{
+		ID:          sandboxID,
+		ContainerID: containerID,
+		Template:    template,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+
+	// Track sandbox
+	p.mu.Lock()
+	p.sandboxes[sandboxID] = sb
+	p.mu.Unlock()
+
+	if err := p.store.Put(sb); err != nil {
+		// The container is already up and tracked in memory; losing the
+		// persisted record only risks it being orphaned on the next
+		// restart, not a correctness problem for this request.
+		log.Printf("failed to persist sandbox %s: %v", sandboxID, err)
+	}
+
+	return sandboxID, expiresAt, nil
+}
+
+// newContainer creates (but does not start) a container for img under name,
+// using the same security/resource config every sandbox container gets,
+// whether it's about to be started immediately (createFromImage) or parked
+// in a warm pool (fillWarmPool) for a later Create to grab.
+func (p *DockerProvider) newContainer(ctx context.Context, img, name string) (string, error) {
 	config := &container.Config{
 		Image:        img,
 		Cmd:          []string{"sleep", "infinity"}, // Keep container running
@@ -104,6 +485,7 @@ func (p *DockerProvider) Create(ctx context.Context, template string, ttl time.D
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
+		Labels:       map[string]string{managedLabel: "true"},
 	}
 
 	hostConfig := &container.HostConfig{
@@ -125,33 +507,223 @@ func (p *DockerProvider) Create(ctx context.Context, template string, ttl time.D
 		// ReadonlyRootfs: true,
 	}
 
-	resp, err := p.client.ContainerCreate(ctx, config, hostConfig, nil, nil, "sandbox-"+sandboxID)
+	resp, err := p.client.ContainerCreate(ctx, config, hostConfig, nil, nil, name)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to create container: %w", err)
+		return "", fmt.Errorf("failed to create container: %w", err)
 	}
+	return resp.ID, nil
+}
 
-	// Start the container
-	if err := p.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		// Cleanup on failure
-		_ = p.client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
-		return "", time.Time{}, fmt.Errorf("failed to start container: %w", err)
+// takeWarm pops one pre-created container off template's warm pool (if any
+// are available) and starts it, giving Create/CreateStream an instant path
+// that skips ContainerCreate entirely. It kicks off an async refill so the
+// pool doesn't stay drained.
+func (p *DockerProvider) takeWarm(template string, ttl time.Duration) (string, time.Time, bool) {
+	p.warmPoolsMu.Lock()
+	pool, ok := p.warmPools[template]
+	if !ok || len(pool.items) == 0 {
+		p.warmPoolsMu.Unlock()
+		return "", time.Time{}, false
 	}
+	wc := pool.items[0]
+	pool.items = pool.items[1:]
+	p.warmPoolsMu.Unlock()
 
+	go p.fillWarmPool(context.Background(), template)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := p.client.ContainerStart(ctx, wc.ContainerID, container.StartOptions{}); err != nil {
+		_ = p.client.ContainerRemove(ctx, wc.ContainerID, container.RemoveOptions{Force: true})
+		return "", time.Time{}, false
+	}
+
+	sandboxID := uuid.New().String()[:8]
 	now := time.Now()
 	expiresAt := now.Add(ttl)
-
-	// Track sandbox
-	p.mu.Lock()
-	p.sandboxes[sandboxID] = &Sandbox{
+	sb := &Sandbox{
 		ID:          sandboxID,
-		ContainerID: resp.ID,
+		ContainerID: wc.ContainerID,
 		Template:    template,
 		CreatedAt:   now,
 		ExpiresAt:   expiresAt,
 	}
+
+	p.mu.Lock()
+	p.sandboxes[sandboxID] = sb
 	p.mu.Unlock()
 
-	return sandboxID, expiresAt, nil
+	if err := p.store.Put(sb); err != nil {
+		log.Printf("failed to persist sandbox %s: %v", sandboxID, err)
+	}
+
+	return sandboxID, expiresAt, true
+}
+
+// fillWarmPool tops template's warm pool back up to its configured size by
+// pre-creating (but not starting) stopped containers ahead of demand.
+func (p *DockerProvider) fillWarmPool(ctx context.Context, template string) {
+	p.warmPoolsMu.Lock()
+	pool, ok := p.warmPools[template]
+	if !ok {
+		p.warmPoolsMu.Unlock()
+		return
+	}
+	need := pool.cfg.Size - len(pool.items)
+	p.warmPoolsMu.Unlock()
+
+	img := templates[template]
+	for i := 0; i < need; i++ {
+		name := "warm-" + template + "-" + uuid.New().String()[:8]
+		containerID, err := p.newContainer(ctx, img, name)
+		if err != nil {
+			return
+		}
+
+		p.warmPoolsMu.Lock()
+		pool.items = append(pool.items, warmContainer{ContainerID: containerID, CreatedAt: time.Now()})
+		p.warmPoolsMu.Unlock()
+	}
+}
+
+// warmPoolLoop periodically refills and TTL-expires one template's warm
+// pool on its own configured cadence, independent of the other templates.
+func (p *DockerProvider) warmPoolLoop(template string) {
+	p.warmPoolsMu.Lock()
+	pool := p.warmPools[template]
+	p.warmPoolsMu.Unlock()
+
+	interval := pool.cfg.RefillInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.reapExpiredWarm(template)
+		p.fillWarmPool(context.Background(), template)
+	}
+}
+
+// reapExpiredWarm removes pooled containers that have sat unused past
+// their template's warm-pool TTL, so an idle pool doesn't hold resources
+// forever.
+func (p *DockerProvider) reapExpiredWarm(template string) {
+	p.warmPoolsMu.Lock()
+	pool, ok := p.warmPools[template]
+	if !ok || pool.cfg.TTL <= 0 {
+		p.warmPoolsMu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	var fresh, stale []warmContainer
+	for _, wc := range pool.items {
+		if now.Sub(wc.CreatedAt) > pool.cfg.TTL {
+			stale = append(stale, wc)
+		} else {
+			fresh = append(fresh, wc)
+		}
+	}
+	pool.items = fresh
+	p.warmPoolsMu.Unlock()
+
+	for _, wc := range stale {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_ = p.client.ContainerRemove(ctx, wc.ContainerID, container.RemoveOptions{Force: true})
+		cancel()
+	}
+}
+
+// Snapshot implements Provider.Snapshot by committing the sandbox's
+// container to a tagged image.
+func (p *DockerProvider) Snapshot(ctx context.Context, sandboxID string, ttl time.Duration) (string, time.Time, time.Time, error) {
+	p.mu.RLock()
+	sandbox, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+	if !ok {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("sandbox not found: %s", sandboxID)
+	}
+
+	snapshotID := uuid.New().String()[:8]
+	ref := "capsule-snapshot:" + snapshotID
+
+	resp, err := p.client.ContainerCommit(ctx, sandbox.ContainerID, container.CommitOptions{Reference: ref})
+	if err != nil {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("failed to commit sandbox %s: %w", sandboxID, err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	snap := &Snapshot{
+		ID:        snapshotID,
+		Image:     resp.ID,
+		Template:  sandbox.Template,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	p.mu.Lock()
+	p.snapshots[snapshotID] = snap
+	p.mu.Unlock()
+
+	if ss, ok := p.store.(SnapshotStore); ok {
+		if err := ss.PutSnapshot(snap); err != nil {
+			// The image is already committed and tracked in memory; losing
+			// the persisted record only risks it being orphaned on the next
+			// restart, not a correctness problem for this request.
+			log.Printf("failed to persist snapshot %s: %v", snapshotID, err)
+		}
+	}
+
+	return snapshotID, now, expiresAt, nil
+}
+
+// Fork implements Provider.Fork by creating a new sandbox from a
+// snapshot's committed image, using the same security/resource config as
+// Create.
+func (p *DockerProvider) Fork(ctx context.Context, snapshotID string, ttl time.Duration) (string, string, time.Time, error) {
+	p.mu.RLock()
+	snap, ok := p.snapshots[snapshotID]
+	p.mu.RUnlock()
+	if !ok {
+		return "", "", time.Time{}, fmt.Errorf("snapshot not found: %s", snapshotID)
+	}
+
+	sandboxID, expiresAt, err := p.createFromImage(ctx, snap.Image, snap.Template, ttl)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return sandboxID, snap.Template, expiresAt, nil
+}
+
+// DeleteSnapshot implements Provider.DeleteSnapshot by removing the
+// snapshot's committed image.
+func (p *DockerProvider) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	p.mu.Lock()
+	snap, ok := p.snapshots[snapshotID]
+	if ok {
+		delete(p.snapshots, snapshotID)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("snapshot not found: %s", snapshotID)
+	}
+
+	if ss, ok := p.store.(SnapshotStore); ok {
+		if err := ss.DeleteSnapshot(snapshotID); err != nil {
+			log.Printf("failed to remove persisted snapshot %s: %v", snapshotID, err)
+		}
+	}
+
+	if _, err := p.client.ImageRemove(ctx, snap.Image, image.RemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("failed to remove snapshot image: %w", err)
+	}
+	return nil
 }
 
 // Exec implements Provider.Exec
@@ -233,6 +805,84 @@ func (p *DockerProvider) Exec(
 		stdoutLimited.Truncated, stderrLimited.Truncated, time.Since(start), nil
 }
 
+// ExecStream implements Provider.ExecStream. It mirrors Exec but writes
+// stdout/stderr to onChunk as stdcopy demultiplexes them instead of
+// buffering the whole output in memory.
+func (p *DockerProvider) ExecStream(
+	ctx context.Context,
+	sandboxID string,
+	cmd []string,
+	cwd string,
+	env map[string]string,
+	maxOut, maxErr int,
+	onChunk func(stream string, data []byte),
+) (exitCode int, timedOut bool, outTrunc, errTrunc bool, duration time.Duration, err error) {
+
+	start := time.Now()
+
+	// Find sandbox
+	p.mu.RLock()
+	sandbox, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return -1, false, false, false, 0, fmt.Errorf("sandbox not found: %s", sandboxID)
+	}
+
+	// Build env slice
+	envSlice := make([]string, 0, len(env))
+	for k, v := range env {
+		envSlice = append(envSlice, k+"="+v)
+	}
+
+	// Working directory
+	workDir := "/workspace"
+	if cwd != "" {
+		workDir = cwd
+	}
+
+	// Create exec instance
+	execConfig := container.ExecOptions{
+		Cmd:          cmd,
+		WorkingDir:   workDir,
+		Env:          envSlice,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	execID, err := p.client.ContainerExecCreate(ctx, sandbox.ContainerID, execConfig)
+	if err != nil {
+		return -1, false, false, false, time.Since(start), fmt.Errorf("exec create failed: %w", err)
+	}
+
+	// Attach to exec
+	resp, err := p.client.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return -1, false, false, false, time.Since(start), fmt.Errorf("exec attach failed: %w", err)
+	}
+	defer resp.Close()
+
+	stdoutW := &streamingWriter{stream: "stdout", onChunk: onChunk, max: maxOut}
+	stderrW := &streamingWriter{stream: "stderr", onChunk: onChunk, max: maxErr}
+
+	// Docker multiplexes stdout/stderr - demux it, forwarding chunks as they arrive
+	_, _ = stdcopy.StdCopy(stdoutW, stderrW, resp.Reader)
+
+	// Check if context timed out
+	if ctx.Err() == context.DeadlineExceeded {
+		timedOut = true
+	}
+
+	// Get exit code
+	inspectResp, err := p.client.ContainerExecInspect(ctx, execID.ID)
+	if err != nil {
+		// If we can't inspect, return what we have
+		return -1, timedOut, stdoutW.truncated, stderrW.truncated, time.Since(start), nil
+	}
+
+	return inspectResp.ExitCode, timedOut, stdoutW.truncated, stderrW.truncated, time.Since(start), nil
+}
+
 // Delete implements Provider.Delete
 func (p *DockerProvider) Delete(ctx context.Context, sandboxID string) error {
 	p.mu.Lock()
@@ -246,6 +896,10 @@ func (p *DockerProvider) Delete(ctx context.Context, sandboxID string) error {
 		return fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
 
+	if err := p.store.Delete(sandboxID); err != nil {
+		log.Printf("failed to remove persisted sandbox %s: %v", sandboxID, err)
+	}
+
 	// Force remove container
 	err := p.client.ContainerRemove(ctx, sandbox.ContainerID, container.RemoveOptions{
 		Force: true,
@@ -264,6 +918,7 @@ func (p *DockerProvider) gcLoop() {
 
 	for range ticker.C {
 		p.cleanupExpired()
+		p.cleanupExpiredSnapshots()
 	}
 }
 
@@ -286,9 +941,32 @@ func (p *DockerProvider) cleanupExpired() {
 	}
 }
 
+func (p *DockerProvider) cleanupExpiredSnapshots() {
+	now := time.Now()
+	var toDelete []string
+
+	p.mu.RLock()
+	for id, snap := range p.snapshots {
+		if now.After(snap.ExpiresAt) {
+			toDelete = append(toDelete, id)
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, id := range toDelete {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_ = p.DeleteSnapshot(ctx, id)
+		cancel()
+	}
+}
 
-// WriteFile writes content to a file in the sandbox
-func (p *DockerProvider) WriteFile(ctx context.Context, sandboxID, path string, content []byte) error {
+// WriteFile writes content to a single file in the sandbox via
+// CopyToContainer, replacing the old sh -c "echo ... | base64 -d" exec
+// (which inherited argv/env size limits and shell injection risk from
+// passing file content as a shell word) with Docker's native archive
+// upload. Docker creates any missing parent directories while extracting
+// the tar, matching the old code's "mkdir -p" behavior.
+func (p *DockerProvider) WriteFile(ctx context.Context, sandboxID, path string, content []byte, mode int64) error {
 	p.mu.RLock()
 	sandbox, ok := p.sandboxes[sandboxID]
 	p.mu.RUnlock()
@@ -297,84 +975,172 @@ func (p *DockerProvider) WriteFile(ctx context.Context, sandboxID, path string,
 		return fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
 
-	// Use base64 to safely transfer binary content
-	encoded := base64.StdEncoding.EncodeToString(content)
+	tarData, err := buildFileTar([]rpc.FileWrite{{Path: path, Content: content, Mode: mode}})
+	if err != nil {
+		return fmt.Errorf("failed to build tar for %s: %w", path, err)
+	}
 
-	// Create parent directories and write file
-	cmd := fmt.Sprintf("mkdir -p $(dirname %s) && echo %s | base64 -d > %s", path, encoded, path)
+	if err := p.client.CopyToContainer(ctx, sandbox.ContainerID, "/", tarData, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy file into container: %w", err)
+	}
 
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"sh", "-c", cmd},
-		AttachStdout: true,
-		AttachStderr: true,
+	return nil
+}
+
+// WriteFiles writes all of files to the sandbox in a single tar stream and
+// CopyToContainer call, which is dramatically faster than one WriteFile
+// (let alone one shell exec) per path for project uploads.
+func (p *DockerProvider) WriteFiles(ctx context.Context, sandboxID string, files []rpc.FileWrite) error {
+	p.mu.RLock()
+	sandbox, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
 
-	execID, err := p.client.ContainerExecCreate(ctx, sandbox.ContainerID, execConfig)
+	tarData, err := buildFileTar(files)
 	if err != nil {
-		return fmt.Errorf("exec create failed: %w", err)
+		return fmt.Errorf("failed to build tar: %w", err)
 	}
 
-	resp, err := p.client.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{})
-	if err != nil {
-		return fmt.Errorf("exec attach failed: %w", err)
+	if err := p.client.CopyToContainer(ctx, sandbox.ContainerID, "/", tarData, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy files into container: %w", err)
 	}
-	defer resp.Close()
 
-	// Wait for completion
-	_, _ = io.Copy(io.Discard, resp.Reader)
+	return nil
+}
 
-	// Check exit code
-	inspectResp, err := p.client.ContainerExecInspect(ctx, execID.ID)
-	if err != nil {
-		return fmt.Errorf("exec inspect failed: %w", err)
+// UploadTar extracts a caller-supplied tar archive at destPath in one call,
+// skipping the per-file overhead of WriteFiles entirely for callers that
+// already have a tar blob (e.g. a project directory) ready to ship.
+func (p *DockerProvider) UploadTar(ctx context.Context, sandboxID, destPath string, tarData []byte) error {
+	p.mu.RLock()
+	sandbox, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
-	if inspectResp.ExitCode != 0 {
-		return fmt.Errorf("write file failed with exit code %d", inspectResp.ExitCode)
+
+	if destPath == "" {
+		destPath = "/"
+	}
+
+	if err := p.client.CopyToContainer(ctx, sandbox.ContainerID, destPath, bytes.NewReader(tarData), container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to extract tar into container: %w", err)
 	}
 
 	return nil
 }
 
-// ReadFile reads content from a file in the sandbox
-func (p *DockerProvider) ReadFile(ctx context.Context, sandboxID, path string) ([]byte, error) {
+// tarEntryName resolves p to the path it should land at once CopyToContainer
+// extracts the tar at container root "/". An absolute path is used as-is
+// (minus its leading slash); a relative path is resolved against
+// /workspace, the working directory every exec in this package runs with,
+// so callers passing e.g. "app.py" keep getting /workspace/app.py the way
+// the old sh -c implementation (which ran with WorkingDir: "/workspace")
+// resolved it.
+func tarEntryName(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return strings.TrimPrefix(p, "/")
+	}
+	return path.Join("workspace", p)
+}
+
+// buildFileTar packs files into an in-memory tar stream suitable for
+// CopyToContainer, setting mode, uid/gid, and mtime the way "docker cp"
+// does so files land with sane permissions instead of whatever the
+// extracting process's umask would otherwise pick.
+func buildFileTar(files []rpc.FileWrite) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+
+	for _, f := range files {
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0o644
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     tarEntryName(f.Path),
+			Mode:     mode,
+			Size:     int64(len(f.Content)),
+			Uid:      0,
+			Gid:      0,
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("failed to write tar header for %s: %w", f.Path, err)
+		}
+		if _, err := tw.Write(f.Content); err != nil {
+			return nil, fmt.Errorf("failed to write tar content for %s: %w", f.Path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize tar: %w", err)
+	}
+	return &buf, nil
+}
+
+// ReadFile reads a single file from the sandbox via CopyFromContainer,
+// replacing the old "cat path" exec with Docker's native archive download
+// so truncation is enforced by limitedWriter instead of the shell.
+func (p *DockerProvider) ReadFile(ctx context.Context, sandboxID, path string, maxBytes int) ([]byte, bool, error) {
 	p.mu.RLock()
 	sandbox, ok := p.sandboxes[sandboxID]
 	p.mu.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("sandbox not found: %s", sandboxID)
+		return nil, false, fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
 
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"cat", path},
-		AttachStdout: true,
-		AttachStderr: true,
+	reader, _, err := p.client.CopyFromContainer(ctx, sandbox.ContainerID, path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read file from container: %w", err)
 	}
+	defer reader.Close()
 
-	execID, err := p.client.ContainerExecCreate(ctx, sandbox.ContainerID, execConfig)
-	if err != nil {
-		return nil, fmt.Errorf("exec create failed: %w", err)
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return nil, false, fmt.Errorf("file not found in archive: %s: %w", path, err)
 	}
 
-	resp, err := p.client.ContainerExecAttach(ctx, execID.ID, container.ExecAttachOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("exec attach failed: %w", err)
+	var out bytes.Buffer
+	limited := &limitedWriter{W: &out, Max: maxBytes}
+	if _, err := io.Copy(limited, tr); err != nil {
+		return nil, false, fmt.Errorf("failed to read file contents: %w", err)
 	}
-	defer resp.Close()
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	_, _ = stdcopy.StdCopy(&stdoutBuf, &stderrBuf, resp.Reader)
+	return out.Bytes(), limited.Truncated, nil
+}
 
-	// Check exit code
-	inspectResp, err := p.client.ContainerExecInspect(ctx, execID.ID)
-	if err != nil {
-		return nil, fmt.Errorf("exec inspect failed: %w", err)
+// ReadFiles reads each of paths independently via ReadFile; a failure on
+// one path is reported in that entry's Err instead of failing the whole
+// batch, since a typo'd path shouldn't sink an otherwise-successful read.
+func (p *DockerProvider) ReadFiles(ctx context.Context, sandboxID string, paths []string, maxBytes int) ([]rpc.FileRead, error) {
+	p.mu.RLock()
+	_, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("sandbox not found: %s", sandboxID)
 	}
-	if inspectResp.ExitCode != 0 {
-		return nil, fmt.Errorf("file not found or read error: %s", stderrBuf.String())
+
+	results := make([]rpc.FileRead, len(paths))
+	for i, path := range paths {
+		content, truncated, err := p.ReadFile(ctx, sandboxID, path, maxBytes)
+		if err != nil {
+			results[i] = rpc.FileRead{Path: path, Err: err.Error()}
+			continue
+		}
+		results[i] = rpc.FileRead{Path: path, Content: content, Truncated: truncated}
 	}
 
-	return stdoutBuf.Bytes(), nil
+	return results, nil
 }
 
 // ListDir lists files in a directory in the sandbox
@@ -455,6 +1221,18 @@ func (p *DockerProvider) GetClient() *client.Client {
 	return p.client
 }
 
+// TerminalBackend implements rpc.TerminalBackendProvider: every Docker
+// sandbox is reached the same way, by exec'ing a shell into its container,
+// so the backend doesn't vary by sandboxID the way it would for a provider
+// that mixes local and remote (SSH) sandboxes.
+func (p *DockerProvider) TerminalBackend(sandboxID string) (rpc.TerminalBackend, error) {
+	containerID, err := p.GetContainerID(sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return &rpc.DockerTerminalBackend{Cli: p.client, ContainerID: containerID}, nil
+}
+
 // limitedWriter wraps a writer with a max byte limit
 type limitedWriter struct {
 	W         io.Writer
@@ -479,3 +1257,34 @@ func (lw *limitedWriter) Write(p []byte) (int, error) {
 	lw.Written += n
 	return n, err
 }
+
+// streamingWriter forwards each write to onChunk (up to a max byte budget)
+// instead of buffering it, used by ExecStream to emit chunk notifications
+// as output arrives.
+type streamingWriter struct {
+	stream    string
+	onChunk   func(stream string, data []byte)
+	max       int
+	written   int
+	truncated bool
+}
+
+func (sw *streamingWriter) Write(p []byte) (int, error) {
+	if sw.written >= sw.max {
+		sw.truncated = true
+		return len(p), nil // Discard but report success
+	}
+
+	data := p
+	remaining := sw.max - sw.written
+	if len(data) > remaining {
+		data = data[:remaining]
+		sw.truncated = true
+	}
+
+	if len(data) > 0 && sw.onChunk != nil {
+		sw.onChunk(sw.stream, data)
+	}
+	sw.written += len(data)
+	return len(p), nil
+}
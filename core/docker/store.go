@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SandboxStore persists sandbox records across restarts so DockerProvider
+// can reconcile its in-memory map against what's actually running in
+// Docker on startup instead of orphaning every container on a deploy.
+// Implementations only need to be correct, not fast: Put/Delete happen
+// once per Create/Delete, never on the Exec hot path.
+type SandboxStore interface {
+	Put(sb *Sandbox) error
+	Delete(sandboxID string) error
+	List() ([]*Sandbox, error)
+	Close() error
+}
+
+var sandboxBucket = []byte("sandboxes")
+var snapshotBucket = []byte("snapshots")
+
+// SnapshotStore persists snapshot records across restarts, the same role
+// SandboxStore plays for sandboxes: without it, DockerProvider.snapshots
+// starts empty on every restart and cleanupExpiredSnapshots/gcLoop can
+// never expire (or even know about) an image committed before the restart,
+// leaking it forever. It's a separate interface from SandboxStore rather
+// than folding Snapshot support into it, since Go methods can't overload
+// on parameter type; BoltStore implements both and reconcile type-asserts
+// p.store to pick it up.
+type SnapshotStore interface {
+	PutSnapshot(snap *Snapshot) error
+	DeleteSnapshot(snapshotID string) error
+	ListSnapshots() ([]*Snapshot, error)
+}
+
+// BoltStore is the default SandboxStore, backed by a single BoltDB file so
+// the server stays restart-safe without an external database dependency.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB file at path and
+// ensures the sandboxes bucket exists.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sandbox store %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(sandboxBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init sandbox store bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Put implements SandboxStore.Put
+func (s *BoltStore) Put(sb *Sandbox) error {
+	data, err := json.Marshal(sb)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sandbox %s: %w", sb.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sandboxBucket).Put([]byte(sb.ID), data)
+	})
+}
+
+// Delete implements SandboxStore.Delete
+func (s *BoltStore) Delete(sandboxID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sandboxBucket).Delete([]byte(sandboxID))
+	})
+}
+
+// List implements SandboxStore.List
+func (s *BoltStore) List() ([]*Sandbox, error) {
+	var out []*Sandbox
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sandboxBucket).ForEach(func(k, v []byte) error {
+			var sb Sandbox
+			if err := json.Unmarshal(v, &sb); err != nil {
+				return fmt.Errorf("failed to unmarshal sandbox %s: %w", k, err)
+			}
+			out = append(out, &sb)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Close implements SandboxStore.Close
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// PutSnapshot implements SnapshotStore.PutSnapshot
+func (s *BoltStore) PutSnapshot(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot %s: %w", snap.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotBucket).Put([]byte(snap.ID), data)
+	})
+}
+
+// DeleteSnapshot implements SnapshotStore.DeleteSnapshot
+func (s *BoltStore) DeleteSnapshot(snapshotID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotBucket).Delete([]byte(snapshotID))
+	})
+}
+
+// ListSnapshots implements SnapshotStore.ListSnapshots
+func (s *BoltStore) ListSnapshots() ([]*Snapshot, error) {
+	var out []*Snapshot
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotBucket).ForEach(func(k, v []byte) error {
+			var snap Snapshot
+			if err := json.Unmarshal(v, &snap); err != nil {
+				return fmt.Errorf("failed to unmarshal snapshot %s: %w", k, err)
+			}
+			out = append(out, &snap)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
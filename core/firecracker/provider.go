@@ -0,0 +1,478 @@
+// provider.go
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/google/uuid"
+
+	rpc "sandbox/core/api"
+)
+
+func init() {
+	rpc.RegisterProvider("firecracker", func(cfg map[string]any) (rpc.Provider, error) {
+		return NewProvider(ConfigFromMap(cfg))
+	})
+}
+
+// Template describes the rootfs/kernel pair and resource shape a
+// sandbox.v1.create template name resolves to on this backend. Unlike
+// Docker's templates map (template -> image string), a microVM needs a
+// kernel image plus a root filesystem, so the resolver is per-backend.
+type Template struct {
+	KernelImage string
+	RootfsImage string
+	VCPUCount   int64
+	MemSizeMib  int64
+}
+
+var templates = map[string]Template{
+	"python": {KernelImage: "/var/lib/capsule/kernels/vmlinux.bin", RootfsImage: "/var/lib/capsule/rootfs/python.ext4", VCPUCount: 1, MemSizeMib: 512},
+	"node":   {KernelImage: "/var/lib/capsule/kernels/vmlinux.bin", RootfsImage: "/var/lib/capsule/rootfs/node.ext4", VCPUCount: 1, MemSizeMib: 512},
+	"go":     {KernelImage: "/var/lib/capsule/kernels/vmlinux.bin", RootfsImage: "/var/lib/capsule/rootfs/go.ext4", VCPUCount: 2, MemSizeMib: 1024},
+}
+
+// Config holds the backend-level settings for the firecracker provider,
+// populated from the registry's map[string]any cfg bag.
+type Config struct {
+	// SocketDir is where per-VM firecracker API unix sockets are created.
+	SocketDir string
+	// VsockCID is the guest CID the capsule-agent listens on inside every
+	// VM for exec requests (the host always sees CID 2).
+	VsockPort uint32
+	// SnapshotDir is where mem+vmstate snapshot pairs produced by Snapshot
+	// are written.
+	SnapshotDir string
+}
+
+// ConfigFromMap reads a Config out of the loosely-typed cfg map the
+// registry passes to every backend factory, applying the same defaults
+// NewProvider would use for a nil cfg.
+func ConfigFromMap(cfg map[string]any) Config {
+	c := Config{SocketDir: "/run/capsule/firecracker", VsockPort: 10000, SnapshotDir: "/var/lib/capsule/snapshots"}
+	if cfg == nil {
+		return c
+	}
+	if v, ok := cfg["socket_dir"].(string); ok && v != "" {
+		c.SocketDir = v
+	}
+	if v, ok := cfg["vsock_port"].(int); ok && v > 0 {
+		c.VsockPort = uint32(v)
+	}
+	if v, ok := cfg["snapshot_dir"].(string); ok && v != "" {
+		c.SnapshotDir = v
+	}
+	return c
+}
+
+// snapshot is a paused microVM's memory + device-state pair produced by
+// Snapshot and consumed by Fork, the Firecracker-native analogue of
+// DockerProvider's committed-image snapshots.
+type snapshot struct {
+	ID        string
+	MemPath   string
+	SnapPath  string
+	Template  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Provider implements the rpc.Provider interface on top of Firecracker
+// microVMs, trading the cap-drop + network=none isolation the Docker
+// backend relies on for hardware-virtualized isolation.
+type Provider struct {
+	cfg        Config
+	sandboxes  map[string]*vm
+	snapshots  map[string]*snapshot
+	mu         sync.RWMutex
+	gcInterval time.Duration
+}
+
+type vm struct {
+	ID         string
+	Template   string
+	SocketPath string
+	VsockPath  string
+	Machine    *sdk.Machine
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	cancelBoot context.CancelFunc
+}
+
+func NewProvider(cfg Config) (*Provider, error) {
+	if err := os.MkdirAll(cfg.SocketDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create firecracker socket dir: %w", err)
+	}
+	if err := os.MkdirAll(cfg.SnapshotDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create firecracker snapshot dir: %w", err)
+	}
+
+	p := &Provider{
+		cfg:        cfg,
+		sandboxes:  make(map[string]*vm),
+		snapshots:  make(map[string]*snapshot),
+		gcInterval: 30 * time.Second,
+	}
+
+	go p.gcLoop()
+
+	return p, nil
+}
+
+// Create implements rpc.Provider.Create by booting a fresh microVM from the
+// template's kernel+rootfs pair.
+func (p *Provider) Create(ctx context.Context, template string, ttl time.Duration) (string, time.Time, error) {
+	tmpl, ok := templates[template]
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("unknown template: %s", template)
+	}
+
+	return p.boot(ctx, template, ttl, func(cfg *sdk.Config) {
+		cfg.KernelImagePath = tmpl.KernelImage
+		cfg.Drives = sdk.NewDrivesBuilder(tmpl.RootfsImage).Build()
+		cfg.MachineCfg = models.MachineConfiguration{
+			VcpuCount:  sdk.Int64(tmpl.VCPUCount),
+			MemSizeMib: sdk.Int64(tmpl.MemSizeMib),
+		}
+	})
+}
+
+// boot creates the per-VM sockets, lets configure fill in either a fresh
+// rootfs+kernel (Create) or a snapshot restore (Fork), and starts the
+// machine, tracking it the same way regardless of which path built it.
+func (p *Provider) boot(ctx context.Context, template string, ttl time.Duration, configure func(cfg *sdk.Config)) (string, time.Time, error) {
+	sandboxID := uuid.New().String()[:8]
+	socketPath := filepath.Join(p.cfg.SocketDir, "sandbox-"+sandboxID+".sock")
+	vsockPath := filepath.Join(p.cfg.SocketDir, "vsock-"+sandboxID+".sock")
+
+	bootCtx, cancel := context.WithCancel(context.Background())
+
+	cfg := sdk.Config{
+		SocketPath: socketPath,
+		VsockDevices: []sdk.VsockDevice{{
+			Path: vsockPath,
+			CID:  3,
+		}},
+	}
+	configure(&cfg)
+
+	machine, err := sdk.NewMachine(bootCtx, cfg)
+	if err != nil {
+		cancel()
+		return "", time.Time{}, fmt.Errorf("failed to configure microVM: %w", err)
+	}
+
+	if err := machine.Start(bootCtx); err != nil {
+		cancel()
+		return "", time.Time{}, fmt.Errorf("failed to start microVM: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	p.mu.Lock()
+	p.sandboxes[sandboxID] = &vm{
+		ID:         sandboxID,
+		Template:   template,
+		SocketPath: socketPath,
+		VsockPath:  vsockPath,
+		Machine:    machine,
+		CreatedAt:  now,
+		ExpiresAt:  expiresAt,
+		cancelBoot: cancel,
+	}
+	p.mu.Unlock()
+
+	return sandboxID, expiresAt, nil
+}
+
+// Snapshot implements rpc.Provider.Snapshot by pausing the microVM and
+// writing its memory + device-state to disk via Firecracker's native
+// snapshot support.
+func (p *Provider) Snapshot(ctx context.Context, sandboxID string, ttl time.Duration) (string, time.Time, time.Time, error) {
+	p.mu.RLock()
+	v, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+	if !ok {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("sandbox not found: %s", sandboxID)
+	}
+
+	if err := v.Machine.PauseVM(ctx); err != nil {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("failed to pause microVM: %w", err)
+	}
+	defer v.Machine.ResumeVM(ctx)
+
+	snapshotID := uuid.New().String()[:8]
+	memPath := filepath.Join(p.cfg.SnapshotDir, snapshotID+".mem")
+	snapPath := filepath.Join(p.cfg.SnapshotDir, snapshotID+".snap")
+
+	if err := v.Machine.CreateSnapshot(ctx, memPath, snapPath); err != nil {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("failed to snapshot microVM: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	p.mu.Lock()
+	p.snapshots[snapshotID] = &snapshot{
+		ID:        snapshotID,
+		MemPath:   memPath,
+		SnapPath:  snapPath,
+		Template:  v.Template,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	p.mu.Unlock()
+
+	return snapshotID, now, expiresAt, nil
+}
+
+// Fork implements rpc.Provider.Fork by restoring a new microVM from a
+// snapshot's mem+vmstate pair instead of booting fresh.
+func (p *Provider) Fork(ctx context.Context, snapshotID string, ttl time.Duration) (string, string, time.Time, error) {
+	p.mu.RLock()
+	snap, ok := p.snapshots[snapshotID]
+	p.mu.RUnlock()
+	if !ok {
+		return "", "", time.Time{}, fmt.Errorf("snapshot not found: %s", snapshotID)
+	}
+
+	sandboxID, expiresAt, err := p.boot(ctx, snap.Template, ttl, func(cfg *sdk.Config) {
+		cfg.Snapshot = sdk.SnapshotConfig{
+			MemFilePath:  snap.MemPath,
+			SnapshotPath: snap.SnapPath,
+		}
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return sandboxID, snap.Template, expiresAt, nil
+}
+
+// DeleteSnapshot implements rpc.Provider.DeleteSnapshot by removing the
+// mem+vmstate files backing the snapshot.
+func (p *Provider) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	p.mu.Lock()
+	snap, ok := p.snapshots[snapshotID]
+	if ok {
+		delete(p.snapshots, snapshotID)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("snapshot not found: %s", snapshotID)
+	}
+
+	_ = os.Remove(snap.MemPath)
+	_ = os.Remove(snap.SnapPath)
+	return nil
+}
+
+// Exec implements rpc.Provider.Exec by dialing the capsule-agent listening
+// on the guest's vsock port and running the command to completion.
+func (p *Provider) Exec(ctx context.Context, sandboxID string, cmd []string, cwd string, env map[string]string, maxOut, maxErr int) (stdout, stderr []byte, exitCode int, timedOut bool, outTrunc, errTrunc bool, duration time.Duration, err error) {
+	start := time.Now()
+
+	var outBuf, errBuf limitedBuffer
+	outBuf.max, errBuf.max = maxOut, maxErr
+
+	code, to, execErr := p.execViaAgent(ctx, sandboxID, cmd, cwd, env, func(stream string, data []byte) {
+		if stream == "stdout" {
+			outBuf.Write(data)
+		} else {
+			errBuf.Write(data)
+		}
+	})
+
+	return outBuf.buf, errBuf.buf, code, to, outBuf.truncated, errBuf.truncated, time.Since(start), execErr
+}
+
+// ExecStream implements rpc.Provider.ExecStream, forwarding agent output
+// straight to onChunk instead of buffering it.
+func (p *Provider) ExecStream(ctx context.Context, sandboxID string, cmd []string, cwd string, env map[string]string, maxOut, maxErr int, onChunk func(stream string, data []byte)) (exitCode int, timedOut bool, outTrunc, errTrunc bool, duration time.Duration, err error) {
+	start := time.Now()
+
+	var outSeen, errSeen int
+	wrap := func(stream string, data []byte) {
+		n, max := &outSeen, maxOut
+		if stream == "stderr" {
+			n, max = &errSeen, maxErr
+		}
+		if *n >= max {
+			if stream == "stdout" {
+				outTrunc = true
+			} else {
+				errTrunc = true
+			}
+			return
+		}
+		if len(data) > max-*n {
+			data = data[:max-*n]
+			if stream == "stdout" {
+				outTrunc = true
+			} else {
+				errTrunc = true
+			}
+		}
+		*n += len(data)
+		if onChunk != nil {
+			onChunk(stream, data)
+		}
+	}
+
+	code, to, execErr := p.execViaAgent(ctx, sandboxID, cmd, cwd, env, wrap)
+	return code, to, outTrunc, errTrunc, time.Since(start), execErr
+}
+
+// Delete implements rpc.Provider.Delete by stopping the microVM and
+// releasing its socket.
+func (p *Provider) Delete(ctx context.Context, sandboxID string) error {
+	p.mu.Lock()
+	v, ok := p.sandboxes[sandboxID]
+	if ok {
+		delete(p.sandboxes, sandboxID)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("sandbox not found: %s", sandboxID)
+	}
+
+	if err := v.Machine.StopVMM(); err != nil {
+		return fmt.Errorf("failed to stop microVM: %w", err)
+	}
+	v.cancelBoot()
+	_ = os.Remove(v.SocketPath)
+
+	return nil
+}
+
+func (p *Provider) gcLoop() {
+	ticker := time.NewTicker(p.gcInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.cleanupExpired()
+	}
+}
+
+func (p *Provider) cleanupExpired() {
+	now := time.Now()
+	var toDelete []string
+
+	p.mu.RLock()
+	for id, v := range p.sandboxes {
+		if now.After(v.ExpiresAt) {
+			toDelete = append(toDelete, id)
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, id := range toDelete {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_ = p.Delete(ctx, id)
+		cancel()
+	}
+}
+
+// execAgentRequest/Response is the wire protocol spoken to capsule-agent,
+// a small process baked into every rootfs image that listens on the
+// vsock port named in Config.VsockPort and runs commands on the agent's
+// behalf, streaming output back as newline-delimited JSON frames.
+type execAgentRequest struct {
+	Cmd []string          `json:"cmd"`
+	Cwd string            `json:"cwd,omitempty"`
+	Env map[string]string `json:"env,omitempty"`
+}
+
+type execAgentFrame struct {
+	Stream   string `json:"stream,omitempty"` // "stdout" | "stderr" | ""
+	DataB64  string `json:"data_b64,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// execViaAgent dials the sandbox's vsock-exposed agent and streams a
+// command's output to onChunk, returning its exit code once the agent
+// reports completion or the context is canceled.
+func (p *Provider) execViaAgent(ctx context.Context, sandboxID string, cmd []string, cwd string, env map[string]string, onChunk func(stream string, data []byte)) (exitCode int, timedOut bool, err error) {
+	p.mu.RLock()
+	v, ok := p.sandboxes[sandboxID]
+	p.mu.RUnlock()
+	if !ok {
+		return -1, false, fmt.Errorf("sandbox not found: %s", sandboxID)
+	}
+
+	conn, err := dialVsock(v.VsockPath, p.cfg.VsockPort)
+	if err != nil {
+		return -1, false, fmt.Errorf("failed to dial capsule-agent: %w", err)
+	}
+	defer conn.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(execAgentRequest{Cmd: cmd, Cwd: cwd, Env: env}); err != nil {
+		return -1, false, fmt.Errorf("failed to send exec request: %w", err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var frame execAgentFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			continue
+		}
+		if frame.Done {
+			return frame.ExitCode, false, nil
+		}
+		if len(frame.DataB64) > 0 && onChunk != nil {
+			data, err := base64.StdEncoding.DecodeString(frame.DataB64)
+			if err == nil {
+				onChunk(frame.Stream, data)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return -1, true, ctx.Err()
+		}
+		return -1, false, fmt.Errorf("agent connection closed unexpectedly: %w", err)
+	}
+
+	return -1, false, fmt.Errorf("agent closed connection without a result")
+}
+
+// limitedBuffer accumulates bytes up to max, matching the truncation
+// semantics of docker.limitedWriter without depending on that package.
+type limitedBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (lb *limitedBuffer) Write(p []byte) {
+	if len(lb.buf) >= lb.max {
+		lb.truncated = true
+		return
+	}
+	remaining := lb.max - len(lb.buf)
+	if len(p) > remaining {
+		p = p[:remaining]
+		lb.truncated = true
+	}
+	lb.buf = append(lb.buf, p...)
+}
@@ -0,0 +1,44 @@
+package firecracker
+
+import (
+	"fmt"
+	"net"
+)
+
+// dialVsock connects to a guest's capsule-agent over Firecracker's
+// host-side vsock UDS. Firecracker exposes vsock as a unix socket on the
+// host; a client connects to it and sends "CONNECT <port>\n", and the
+// guest-side listener on that port receives the rest of the byte stream
+// once Firecracker replies "OK <assigned_port>\n".
+func dialVsock(hostSocketPath string, port uint32) (net.Conn, error) {
+	conn, err := net.Dial("unix", hostSocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial vsock socket %s: %w", hostSocketPath, err)
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send vsock CONNECT: %w", err)
+	}
+
+	// Read the "OK <assigned_port>\n" ack one byte at a time so we don't
+	// buffer (and lose) any bytes the agent sends immediately after.
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read vsock CONNECT ack: %w", err)
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	if len(line) < 2 || string(line[:2]) != "OK" {
+		conn.Close()
+		return nil, fmt.Errorf("vsock CONNECT to port %d refused: %s", port, line)
+	}
+
+	return conn, nil
+}
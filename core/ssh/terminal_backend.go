@@ -0,0 +1,201 @@
+// Package ssh implements rpc.TerminalBackend for sandboxes that live on a
+// remote host reachable over SSH rather than as a local Docker container,
+// e.g. a bare-metal box or a VM with no local exec API.
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	rpc "sandbox/core/api"
+)
+
+// Config names the remote host and how to authenticate to it. Exactly one
+// of Password or PrivateKey should be set; PrivateKey wins if both are.
+// Passphrase is only consulted when PrivateKey is itself encrypted.
+//
+// The remote host's key is verified against KnownHostsFile (OpenSSH
+// known_hosts format) unless InsecureSkipHostKeyCheck is set, which
+// disables verification entirely and should only ever be used for local
+// testing against a throwaway host.
+type Config struct {
+	Host string
+	Port int
+	User string
+
+	Password   string
+	PrivateKey []byte
+	Passphrase string
+
+	KnownHostsFile           string
+	InsecureSkipHostKeyCheck bool
+}
+
+// hostKeyCallback resolves Config's host-key verification policy: entries
+// from KnownHostsFile by default, or ssh.InsecureIgnoreHostKey if the
+// caller has explicitly opted out of verification.
+func (c Config) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.InsecureSkipHostKeyCheck {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if c.KnownHostsFile == "" {
+		return nil, fmt.Errorf("ssh: KnownHostsFile is required unless InsecureSkipHostKeyCheck is set")
+	}
+	cb, err := knownhosts.New(c.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: load known hosts: %w", err)
+	}
+	return cb, nil
+}
+
+// addr returns host:port, defaulting Port to the standard SSH port.
+func (c Config) addr() string {
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	return fmt.Sprintf("%s:%d", c.Host, port)
+}
+
+// authMethod resolves Config's credentials into the ssh.AuthMethod to
+// dial with, preferring a private key over a bare password the same way
+// ssh(1) tries identity files before falling back to password auth.
+func (c Config) authMethod() (ssh.AuthMethod, error) {
+	if len(c.PrivateKey) > 0 {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if c.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(c.PrivateKey, []byte(c.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(c.PrivateKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+	return ssh.Password(c.Password), nil
+}
+
+// ConfigResolver looks up the Config to dial for a given sandboxID, so a
+// single TerminalBackend can serve a whole fleet of remote hosts rather
+// than one fixed target.
+type ConfigResolver func(sandboxID string) (Config, error)
+
+// TerminalBackend implements rpc.TerminalBackend by dialing a remote host
+// over SSH and starting an interactive shell in a PTY, the SSH-backed
+// counterpart to rpc.DockerTerminalBackend.
+type TerminalBackend struct {
+	Configs ConfigResolver
+}
+
+// Start implements rpc.TerminalBackend.
+func (b *TerminalBackend) Start(ctx context.Context, sandboxID string, cols, rows uint16) (io.ReadWriteCloser, rpc.ResizeFunc, error) {
+	cfg, err := b.Configs(sandboxID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auth, err := cfg.authMethod()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := cfg.hostKeyCallback()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientCfg := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.addr())
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh dial: %w", err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, cfg.addr(), clientCfg)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("ssh handshake: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("ssh session: %w", err)
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", int(rows), int(cols), modes); err != nil {
+		session.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("ssh request pty: %w", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("ssh stdin pipe: %w", err)
+	}
+
+	// A TTY session conventionally merges stdout and stderr into one
+	// stream (Docker's hijacked exec connection does the same with
+	// Tty:true), so both are pointed at the same pipe writer.
+	pr, pw := io.Pipe()
+	session.Stdout = pw
+	session.Stderr = pw
+
+	if err := session.Shell(); err != nil {
+		session.Close()
+		client.Close()
+		return nil, nil, fmt.Errorf("ssh shell: %w", err)
+	}
+
+	go func() {
+		pw.CloseWithError(session.Wait())
+	}()
+
+	rwc := &sessionConn{Reader: pr, stdin: stdin, session: session, client: client}
+	resize := func(cols, rows uint16) error {
+		return session.WindowChange(int(rows), int(cols))
+	}
+	return rwc, resize, nil
+}
+
+// sessionConn adapts an ssh.Session's separate stdin writer and merged
+// stdout/stderr reader into a single io.ReadWriteCloser, the shape every
+// TerminalBackend hands back. Closing it tears down the session and the
+// client connection underneath it.
+type sessionConn struct {
+	io.Reader
+	stdin   io.WriteCloser
+	session *ssh.Session
+	client  *ssh.Client
+}
+
+func (c *sessionConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *sessionConn) Close() error {
+	c.session.Close()
+	return c.client.Close()
+}